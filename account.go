@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrAlreadyExists = errors.New("already exists")
+)
+
+// Account is the resource served under /account/{id}
+type Account struct {
+	ID      string `json:"account_id"`
+	Name    string `json:"name"`
+	Balance Money  `json:"balance"`
+}
+
+// AccountStore persists accounts
+type AccountStore interface {
+	Get(ctx context.Context, id string) (Account, error)
+	Put(ctx context.Context, a Account) error
+	// Create stores a unless its id is already taken, in which case it
+	// fails with ErrAlreadyExists. The check and the write are atomic.
+	Create(ctx context.Context, a Account) error
+	// Update applies fn to the stored account atomically
+	Update(ctx context.Context, id string, fn func(*Account) error) (Account, error)
+	// List returns up to limit accounts ordered by id, starting after
+	// cursor, plus the cursor for the next page ("" when there is none)
+	List(ctx context.Context, limit int, cursor string) ([]Account, string, error)
+}
+
+// MapStore is an in-memory AccountStore, safe for concurrent use. Reads
+// share an RLock; writes take the full lock.
+type MapStore struct {
+	mu       sync.RWMutex
+	accounts map[string]Account
+}
+
+func NewMapStore() *MapStore {
+	return &MapStore{accounts: map[string]Account{}}
+}
+
+func (s *MapStore) Get(ctx context.Context, id string) (Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	a, ok := s.accounts[id]
+	if !ok {
+		return Account{}, ErrNotFound
+	}
+	return a, nil
+}
+
+func (s *MapStore) Put(ctx context.Context, a Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accounts[a.ID] = a
+	return nil
+}
+
+func (s *MapStore) Create(ctx context.Context, a Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.accounts[a.ID]; ok {
+		return ErrAlreadyExists
+	}
+	s.accounts[a.ID] = a
+	return nil
+}
+
+func (s *MapStore) Update(ctx context.Context, id string, fn func(*Account) error) (Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.accounts[id]
+	if !ok {
+		return Account{}, ErrNotFound
+	}
+	if err := fn(&a); err != nil {
+		return Account{}, err
+	}
+	s.accounts[id] = a
+	return a, nil
+}
+
+func (s *MapStore) List(ctx context.Context, limit int, cursor string) ([]Account, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := make([]string, 0, len(s.accounts))
+	for id := range s.accounts {
+		if id > cursor {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	next := ""
+	if len(ids) > limit {
+		ids = ids[:limit]
+		next = ids[limit-1]
+	}
+	items := make([]Account, len(ids))
+	for i, id := range ids {
+		items[i] = s.accounts[id]
+	}
+	return items, next, nil
+}
+
+// Longest account name accepted, in characters
+const maxNameLength = 100
+
+// validateAccount checks a new account before it is stored
+func validateAccount(a Account) error {
+	var v Validator
+	v.Required("account_id", a.ID)
+	v.Required("name", a.Name)
+	v.Length("name", a.Name, 1, maxNameLength)
+	v.Range("balance", int64(a.Balance), 0, math.MaxInt64)
+	return v.Err()
+}
+
+// CreateAccount stores the account in the request body, answering 201 with
+// a Location header, or 409 if the id is already taken. Callers may only
+// create their own account unless they are admins; it goes behind
+// AuthenticateMiddleware.
+func CreateAccount(store AccountStore) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		var a Account
+		if err := decodeJSON(req, &a); err != nil {
+			writeError(rw, err)
+			return
+		}
+		if err := validateAccount(a); err != nil {
+			writeError(rw, err)
+			return
+		}
+		if p, ok := PrincipalFromContext(req.Context()); !ok || !p.mayAccess(a.ID) {
+			writeError(rw, &APIError{HTTPStatus: http.StatusForbidden, ErrorCode: "forbidden", Message: "ownership not matched", Err: ErrForbidden})
+			return
+		}
+		if err := store.Create(req.Context(), a); err != nil {
+			writeStoreError(rw, req, err)
+			return
+		}
+		rw.Header().Set("Location", "/account/"+url.PathEscape(a.ID))
+		RespondJSON(rw, req, http.StatusCreated, a)
+	}
+}
+
+// accountPatch holds the fields a PATCH may change. A nil field was absent
+// from the body and is left alone; a non-nil zero is an explicit zero.
+type accountPatch struct {
+	Name    *string `json:"name"`
+	Balance *Money  `json:"balance"`
+}
+
+func (p accountPatch) empty() bool {
+	return p.Name == nil && p.Balance == nil
+}
+
+func (p accountPatch) validate() error {
+	var v Validator
+	if p.Name != nil {
+		v.Required("name", *p.Name)
+		v.Length("name", *p.Name, 1, maxNameLength)
+	}
+	if p.Balance != nil {
+		v.Range("balance", int64(*p.Balance), 0, math.MaxInt64)
+	}
+	return v.Err()
+}
+
+func (p accountPatch) apply(a *Account) {
+	if p.Name != nil {
+		a.Name = *p.Name
+	}
+	if p.Balance != nil {
+		a.Balance = *p.Balance
+	}
+}
+
+// PatchAccount updates only the fields present in the request body
+func PatchAccount(store AccountStore) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		var patch accountPatch
+		if err := decodeJSON(req, &patch); err != nil {
+			writeError(rw, err)
+			return
+		}
+		if patch.empty() {
+			respondError(rw, req, http.StatusBadRequest, "empty patch")
+			return
+		}
+		if err := patch.validate(); err != nil {
+			writeError(rw, err)
+			return
+		}
+		a, err := store.Update(req.Context(), mux.Vars(req)["id"], func(a *Account) error {
+			patch.apply(a)
+			return nil
+		})
+		if err != nil {
+			writeStoreError(rw, req, err)
+			return
+		}
+		RespondJSON(rw, req, http.StatusOK, a)
+	}
+}
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+type accountPage struct {
+	Items      []Account `json:"items"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// ListAccounts pages through accounts. "limit" defaults to 20 and is clamped
+// to 100; "cursor" is the opaque next_cursor from a previous page.
+func ListAccounts(store AccountStore) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+
+		limit := defaultListLimit
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				respondError(rw, req, http.StatusBadRequest, "limit must be a non-negative integer")
+				return
+			}
+			if n > 0 {
+				limit = n
+			}
+		}
+		if limit > maxListLimit {
+			limit = maxListLimit
+		}
+
+		cursor, err := base64.RawURLEncoding.DecodeString(q.Get("cursor"))
+		if err != nil {
+			respondError(rw, req, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+
+		items, next, err := store.List(req.Context(), limit, string(cursor))
+		if err != nil {
+			writeStoreError(rw, req, err)
+			return
+		}
+		page := accountPage{Items: items}
+		if next != "" {
+			page.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(next))
+		}
+		RespondJSON(rw, req, http.StatusOK, page)
+	}
+}
+
+// How long a store lookup shared between GetAccountHandler requests may run
+const sharedLookupTimeout = 10 * time.Second
+
+// GetAccountHandler serves the stored account for GET and HEAD. The body is
+// encoded up front so both methods carry identical ETag and Content-Length
+// headers; HEAD just skips writing it. Concurrent requests for the same
+// account share one store lookup. It runs detached from any one caller's
+// cancellation, bounded by sharedLookupTimeout, and each caller stops
+// waiting for it when its own request is cancelled.
+func GetAccountHandler(store AccountStore) http.HandlerFunc {
+	var group singleflight.Group
+	return func(rw http.ResponseWriter, req *http.Request) {
+		id := mux.Vars(req)["id"]
+		key := id
+		if t, ok := TenantFromContext(req.Context()); ok {
+			key = t.ID + "/" + id
+		}
+		ch := group.DoChan(key, func() (interface{}, error) {
+			ctx, cancel := context.WithTimeout(detach(req.Context()), sharedLookupTimeout)
+			defer cancel()
+			return store.Get(ctx, id)
+		})
+		var res singleflight.Result
+		select {
+		case res = <-ch:
+		case <-req.Context().Done():
+			return
+		}
+		if res.Shared {
+			coalescedLookups.Inc()
+		}
+		if res.Err != nil {
+			writeStoreError(rw, req, res.Err)
+			return
+		}
+		a := res.Val.(Account)
+		body, err := json.Marshal(successBody(req, a))
+		if err != nil {
+			writeStoreError(rw, req, err)
+			return
+		}
+		body = append(body, '\n')
+
+		h := rw.Header()
+		h.Set("Content-Type", "application/json")
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+		h.Set("ETag", accountETag(a))
+		rw.WriteHeader(http.StatusOK)
+		if req.Method != http.MethodHead {
+			rw.Write(body)
+		}
+	}
+}
+
+// detachedContext keeps the values of the context it wraps but none of its
+// deadline or cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+// detach returns a context carrying ctx's values that is never cancelled
+func detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// StreamAccountHandler serves the stored account like GetAccountHandler but
+// encodes it straight onto the response instead of buffering it, for
+// accounts too large to hold twice in memory. The ETag is derived from the
+// account as for GetAccountHandler, but no Content-Length can be sent, so
+// the response is chunked. Lookup errors are reported before the status
+// line; once encoding starts the 200 is committed, and a failure part-way
+// only truncates the body.
+func StreamAccountHandler(store AccountStore) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		a, err := store.Get(req.Context(), mux.Vars(req)["id"])
+		if err != nil {
+			writeStoreError(rw, req, err)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("ETag", accountETag(a))
+		rw.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(rw).Encode(successBody(req, a)); err != nil {
+			fmt.Println("stream account: encode failed:", err)
+			return
+		}
+		if f, ok := rw.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+}
+
+// GetAccountV2 is the candidate replacement for GetAccountHandler, served
+// to the share of requests RegisterAccountRoutes' rollout flag selects. It
+// streams the account like StreamAccountHandler, trading the shared lookup
+// and Content-Length for not buffering the body; the ETag is the same.
+func GetAccountV2(store AccountStore) http.HandlerFunc {
+	return StreamAccountHandler(store)
+}
+
+// etagOf returns a strong ETag for a response body
+func etagOf(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// accountETag is the version of a, taken over the account alone so it
+// doesn't vary with the response envelope
+func accountETag(a Account) string {
+	body, _ := json.Marshal(a)
+	return etagOf(body)
+}
+
+// etagMatches reports whether an If-Match header value lists etag
+func etagMatches(header, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		if v = strings.TrimSpace(v); v == "*" || v == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// PutAccount replaces the account at {id} with the request body. It uses
+// optimistic concurrency: If-Match must carry the account's current ETag,
+// and a stale one gets a 412 so concurrent writers can't overwrite each
+// other unseen. A request without If-Match gets a 428 when requireIfMatch
+// is set, and is applied unconditionally otherwise.
+func PutAccount(store AccountStore, requireIfMatch bool) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		ifMatch := req.Header.Get("If-Match")
+		if ifMatch == "" && requireIfMatch {
+			respondError(rw, req, http.StatusPreconditionRequired, "If-Match header required")
+			return
+		}
+		var in Account
+		if err := decodeJSON(req, &in); err != nil {
+			writeError(rw, err)
+			return
+		}
+		in.ID = mux.Vars(req)["id"]
+		if err := validateAccount(in); err != nil {
+			writeError(rw, err)
+			return
+		}
+		a, err := store.Update(req.Context(), in.ID, func(a *Account) error {
+			if ifMatch != "" && !etagMatches(ifMatch, accountETag(*a)) {
+				return ErrPreconditionFailed
+			}
+			*a = in
+			return nil
+		})
+		if err != nil {
+			writeStoreError(rw, req, err)
+			return
+		}
+		rw.Header().Set("ETag", accountETag(a))
+		RespondJSON(rw, req, http.StatusOK, a)
+	}
+}
+
+// RegisterAccountRoutes mounts the store-backed account endpoints on r.
+// /accounts and /account/bulk require a caller authenticated under auth, and
+// listing accounts requires the admin role; /account/{id} additionally
+// requires the caller to own the account. Only /openapi.json is public. rollout selects the share of account reads
+// GetAccountV2 serves; nil keeps them all on GetAccountHandler.
+func RegisterAccountRoutes(r *mux.Router, store AccountStore, auth AuthConfig, rollout *FeatureFlag) {
+	authenticated := AuthenticateMiddleware(auth)
+	owner := AccountAuthMiddleware(auth)
+	r.Handle("/accounts", With(ListAccounts(store), authenticated, RequireRole(adminRole))).Methods(http.MethodGet)
+	r.Handle("/accounts", With(CreateAccount(store), authenticated)).Methods(http.MethodPost)
+	r.Handle("/account/bulk", With(BulkImportHandler(store), authenticated)).Methods(http.MethodPost)
+	r.Handle("/account/{id}", With(rollout.Split(GetAccountV2(store), GetAccountHandler(store)), owner)).Methods(http.MethodGet, http.MethodHead)
+	r.Handle("/account/{id}", With(PatchAccount(store), owner)).Methods(http.MethodPatch)
+	r.Handle("/account/{id}", With(PutAccount(store, true), owner)).Methods(http.MethodPut)
+	r.HandleFunc("/openapi.json", OpenAPIHandler(r)).Methods(http.MethodGet)
+}
+
+// writeStoreError writes an AccountStore error as an APIError, worded for
+// accounts and translated to the request's locale
+func writeStoreError(rw http.ResponseWriter, req *http.Request, err error) {
+	e := *apiErrorFor(err)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		e.Message = "account not found"
+	case errors.Is(err, ErrAlreadyExists):
+		e.Message = "account already exists"
+	case errors.Is(err, ErrCircuitOpen):
+		e.Message = "account store unavailable"
+	}
+	e.Message = localize(req, e.Message)
+	writeAPIError(rw, &e)
+}
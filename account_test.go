@@ -0,0 +1,565 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// gatedStore blocks Get until release is closed, failing with the context
+// error if the lookup's context ends first
+type gatedStore struct {
+	AccountStore
+	entered chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (s *gatedStore) Get(ctx context.Context, id string) (Account, error) {
+	s.once.Do(func() { close(s.entered) })
+	select {
+	case <-s.release:
+		return s.AccountStore.Get(ctx, id)
+	case <-ctx.Done():
+		return Account{}, ctx.Err()
+	}
+}
+
+func TestGetAccountHandlerSharedLookupOutlivesFirstCaller(t *testing.T) {
+	store := &gatedStore{AccountStore: NewMapStore(), entered: make(chan struct{}), release: make(chan struct{})}
+	store.Put(context.Background(), Account{ID: "1", Name: "a"})
+	r := NewRouter()
+	r.Handle("/account/{id}", GetAccountHandler(store))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	firstDone := make(chan struct{})
+	go func() {
+		defer close(firstDone)
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/account/1", nil).WithContext(ctx))
+	}()
+	<-store.entered
+
+	second := httptest.NewRecorder()
+	secondDone := make(chan struct{})
+	go func() {
+		defer close(secondDone)
+		r.ServeHTTP(second, httptest.NewRequest(http.MethodGet, "/account/1", nil))
+	}()
+	time.Sleep(20 * time.Millisecond) // let the second request join the lookup
+
+	cancel()
+	<-firstDone
+	close(store.release)
+	<-secondDone
+
+	if second.Code != http.StatusOK {
+		t.Errorf("second caller status = %d, want 200: %s", second.Code, second.Body)
+	}
+}
+
+// countingStore counts Get calls and holds each one until release is
+// closed
+type countingStore struct {
+	AccountStore
+	calls   int32
+	release chan struct{}
+}
+
+func (s *countingStore) Get(ctx context.Context, id string) (Account, error) {
+	atomic.AddInt32(&s.calls, 1)
+	<-s.release
+	return s.AccountStore.Get(ctx, id)
+}
+
+func TestGetAccountHandlerSharesLookups(t *testing.T) {
+	store := &countingStore{AccountStore: NewMapStore(), release: make(chan struct{})}
+	store.AccountStore.Put(context.Background(), Account{ID: "1", Name: "a"})
+	r := mux.NewRouter()
+	r.Handle("/account/{id}", GetAccountHandler(store))
+
+	const n = 20
+	codes := make(chan int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/account/1", nil))
+			codes <- rec.Code
+		}()
+	}
+	time.Sleep(20 * time.Millisecond) // let every request join the lookup
+	close(store.release)
+	wg.Wait()
+	close(codes)
+
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("status = %d, want 200", code)
+		}
+	}
+	if calls := atomic.LoadInt32(&store.calls); calls != 1 {
+		t.Errorf("store.Get called %d times, want 1", calls)
+	}
+}
+
+func TestCreateAccountValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantFields map[string]string
+	}{
+		{"every field invalid", `{"name":"","balance":-1}`, map[string]string{
+			"account_id": "required",
+			"name":       "required",
+			"balance":    "must be between 0 and 9223372036854775807",
+		}},
+		{"name too long", `{"account_id":"1","name":"` + strings.Repeat("x", maxNameLength+1) + `"}`, map[string]string{
+			"name": "must be at most 100 characters",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMapStore()
+			rec := httptest.NewRecorder()
+			CreateAccount(store).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/accounts", strings.NewReader(tt.body)))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400: %s", rec.Code, rec.Body)
+			}
+			var body struct {
+				Fields map[string]string `json:"fields"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body.Fields) != len(tt.wantFields) {
+				t.Errorf("fields = %v, want %v", body.Fields, tt.wantFields)
+			}
+			for field, want := range tt.wantFields {
+				if got := body.Fields[field]; got != want {
+					t.Errorf("fields[%q] = %q, want %q", field, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateAccountConcurrentSameID(t *testing.T) {
+	r := NewRouter()
+	r.Handle("/accounts", CreateAccount(NewMapStore()))
+
+	const n = 20
+	codes := make(chan int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/accounts", strings.NewReader(`{"account_id":"1","name":"a"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req = req.WithContext(WithPrincipal(req.Context(), &Principal{ID: "1"}))
+			r.ServeHTTP(rec, req)
+			codes <- rec.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	got := map[int]int{}
+	for code := range codes {
+		got[code]++
+	}
+	if got[http.StatusCreated] != 1 || got[http.StatusConflict] != n-1 {
+		t.Errorf("statuses = %v, want one 201 and %d 409s", got, n-1)
+	}
+}
+
+func TestMapStoreCreate(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []Account
+		create   Account
+		wantErr  error
+	}{
+		{"new id", nil, Account{ID: "1", Name: "a"}, nil},
+		{"taken id", []Account{{ID: "1", Name: "a"}}, Account{ID: "1", Name: "b"}, ErrAlreadyExists},
+		{"other id", []Account{{ID: "1", Name: "a"}}, Account{ID: "2", Name: "b"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := NewMapStore()
+			for _, a := range tt.existing {
+				s.Put(ctx, a)
+			}
+			if err := s.Create(ctx, tt.create); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Create = %v, want %v", err, tt.wantErr)
+			}
+			got, _ := s.Get(ctx, tt.create.ID)
+			if tt.wantErr == nil && got != tt.create {
+				t.Errorf("stored %+v, want %+v", got, tt.create)
+			}
+			if tt.wantErr != nil && got == tt.create {
+				t.Error("Create overwrote the existing account")
+			}
+		})
+	}
+}
+
+// TestMapStoreConcurrentAccess is meant for go test -race: each operation
+// runs from many goroutines against a store others are writing to
+func TestMapStoreConcurrentAccess(t *testing.T) {
+	tests := []struct {
+		name string
+		op   func(s *MapStore, ctx context.Context, id string)
+	}{
+		{"Get", func(s *MapStore, ctx context.Context, id string) { s.Get(ctx, id) }},
+		{"Put", func(s *MapStore, ctx context.Context, id string) { s.Put(ctx, Account{ID: id}) }},
+		{"Create", func(s *MapStore, ctx context.Context, id string) { s.Create(ctx, Account{ID: id}) }},
+		{"Update", func(s *MapStore, ctx context.Context, id string) {
+			s.Update(ctx, id, func(a *Account) error { a.Balance++; return nil })
+		}},
+		{"List", func(s *MapStore, ctx context.Context, id string) { s.List(ctx, 10, "") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := NewMapStore()
+			var wg sync.WaitGroup
+			for g := 0; g < 16; g++ {
+				wg.Add(2)
+				go func(g int) {
+					defer wg.Done()
+					for i := 0; i < 100; i++ {
+						s.Put(ctx, Account{ID: strconv.Itoa((g + i) % 10)})
+					}
+				}(g)
+				go func(g int) {
+					defer wg.Done()
+					for i := 0; i < 100; i++ {
+						tt.op(s, ctx, strconv.Itoa((g*7+i)%10))
+					}
+				}(g)
+			}
+			wg.Wait()
+			if items, _, _ := s.List(ctx, 100, ""); len(items) != 10 {
+				t.Errorf("store holds %d accounts, want 10", len(items))
+			}
+		})
+	}
+}
+
+func TestRegisterAccountRoutesRequireAuth(t *testing.T) {
+	tests := []struct {
+		method, path, auth, body string
+		want                     int
+	}{
+		{http.MethodGet, "/accounts", "", "", http.StatusUnauthorized},
+		{http.MethodPost, "/accounts", "", `{"account_id":"2","name":"b"}`, http.StatusUnauthorized},
+		{http.MethodPost, "/account/bulk", "", `{"account_id":"2","name":"b"}`, http.StatusUnauthorized},
+		{http.MethodGet, "/account/1", "", "", http.StatusUnauthorized},
+		{http.MethodPatch, "/account/1", "", `{"name":"c"}`, http.StatusUnauthorized},
+		{http.MethodPut, "/account/1", "", `{"account_id":"1","name":"c"}`, http.StatusUnauthorized},
+		{http.MethodPatch, "/account/1", "2", `{"name":"c"}`, http.StatusForbidden},
+		{http.MethodPut, "/account/1", "2", `{"account_id":"1","name":"c"}`, http.StatusForbidden},
+		{http.MethodGet, "/account/1", "1", "", http.StatusOK},
+		{http.MethodPatch, "/account/1", "1", `{"name":"c"}`, http.StatusOK},
+		{http.MethodPost, "/accounts", "2", `{"account_id":"2","name":"b"}`, http.StatusCreated},
+		{http.MethodPost, "/accounts", "2", `{"account_id":"3","name":"b"}`, http.StatusForbidden},
+		{http.MethodGet, "/accounts", "1", "", http.StatusForbidden},
+		{http.MethodGet, "/openapi.json", "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path+" as "+tt.auth, func(t *testing.T) {
+			store := NewMapStore()
+			store.Put(context.Background(), Account{ID: "1", Name: "a"})
+			r := NewRouter()
+			RegisterAccountRoutes(r, store, AuthConfig{}, nil)
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestPatchAccount(t *testing.T) {
+	tests := []struct {
+		name       string
+		id         string
+		body       string
+		wantStatus int
+		want       Account
+	}{
+		{"name only", "1", `{"name":"renamed"}`, http.StatusOK, Account{ID: "1", Name: "renamed", Balance: 500}},
+		{"balance only", "1", `{"balance":"7.25"}`, http.StatusOK, Account{ID: "1", Name: "orig", Balance: 725}},
+		{"explicit zero balance", "1", `{"balance":0}`, http.StatusOK, Account{ID: "1", Name: "orig", Balance: 0}},
+		{"both", "1", `{"name":"b","balance":"1.00"}`, http.StatusOK, Account{ID: "1", Name: "b", Balance: 100}},
+		{"null leaves field alone", "1", `{"name":"c","balance":null}`, http.StatusOK, Account{ID: "1", Name: "c", Balance: 500}},
+		{"empty patch", "1", `{}`, http.StatusBadRequest, Account{ID: "1", Name: "orig", Balance: 500}},
+		{"empty name", "1", `{"name":""}`, http.StatusBadRequest, Account{ID: "1", Name: "orig", Balance: 500}},
+		{"negative balance", "1", `{"balance":"-1.00"}`, http.StatusBadRequest, Account{ID: "1", Name: "orig", Balance: 500}},
+		{"malformed", "1", `{"name":`, http.StatusBadRequest, Account{ID: "1", Name: "orig", Balance: 500}},
+		{"unknown account", "2", `{"name":"x"}`, http.StatusNotFound, Account{ID: "1", Name: "orig", Balance: 500}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMapStore()
+			store.Put(context.Background(), Account{ID: "1", Name: "orig", Balance: 500})
+			r := NewRouter()
+			r.Handle("/account/{id}", PatchAccount(store)).Methods(http.MethodPatch)
+
+			req := httptest.NewRequest(http.MethodPatch, "/account/"+tt.id, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			got, err := store.Get(context.Background(), "1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("stored %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// listPage fetches one page from ListAccounts
+func listPage(t *testing.T, h http.Handler, query string) (int, accountPage) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/accounts"+query, nil))
+	var body struct {
+		Data accountPage `json:"data"`
+	}
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, body.Data
+}
+
+func TestListAccounts(t *testing.T) {
+	store := NewMapStore()
+	for i := 0; i < 150; i++ {
+		store.Put(context.Background(), Account{ID: fmt.Sprintf("%03d", i), Name: "a"})
+	}
+	h := ListAccounts(store)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantItems  int
+		wantNext   bool
+	}{
+		{"default limit", "", http.StatusOK, defaultListLimit, true},
+		{"zero means default", "?limit=0", http.StatusOK, defaultListLimit, true},
+		{"explicit limit", "?limit=5", http.StatusOK, 5, true},
+		{"clamped to max", "?limit=500", http.StatusOK, maxListLimit, true},
+		{"negative", "?limit=-1", http.StatusBadRequest, 0, false},
+		{"not an integer", "?limit=ten", http.StatusBadRequest, 0, false},
+		{"malformed cursor", "?cursor=!!", http.StatusBadRequest, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, page := listPage(t, h, tt.query)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if len(page.Items) != tt.wantItems {
+				t.Errorf("%d items, want %d", len(page.Items), tt.wantItems)
+			}
+			if (page.NextCursor != "") != tt.wantNext {
+				t.Errorf("next_cursor = %q", page.NextCursor)
+			}
+		})
+	}
+
+	t.Run("cursor round trip", func(t *testing.T) {
+		seen := map[string]bool{}
+		query := "?limit=40"
+		for pages := 0; ; pages++ {
+			if pages > 10 {
+				t.Fatal("pagination did not end")
+			}
+			status, page := listPage(t, h, query)
+			if status != http.StatusOK {
+				t.Fatalf("status = %d", status)
+			}
+			for _, a := range page.Items {
+				if seen[a.ID] {
+					t.Fatalf("account %s returned twice", a.ID)
+				}
+				seen[a.ID] = true
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			query = "?limit=40&cursor=" + page.NextCursor
+		}
+		if len(seen) != 150 {
+			t.Errorf("paged through %d accounts, want 150", len(seen))
+		}
+	})
+}
+
+func TestGetAccountHead(t *testing.T) {
+	store := NewMapStore()
+	store.Put(context.Background(), Account{ID: "1", Name: "a", Balance: 100})
+	r := NewRouter()
+	r.Handle("/account/{id}", GetAccountHandler(store)).Methods(http.MethodGet, http.MethodHead)
+
+	tests := []struct {
+		name, id   string
+		wantStatus int
+	}{
+		{"existing", "1", http.StatusOK},
+		{"missing", "2", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			get, head := httptest.NewRecorder(), httptest.NewRecorder()
+			r.ServeHTTP(get, httptest.NewRequest(http.MethodGet, "/account/"+tt.id, nil))
+			r.ServeHTTP(head, httptest.NewRequest(http.MethodHead, "/account/"+tt.id, nil))
+
+			if get.Code != tt.wantStatus || head.Code != tt.wantStatus {
+				t.Errorf("GET = %d, HEAD = %d, want %d", get.Code, head.Code, tt.wantStatus)
+			}
+			// net/http drops HEAD bodies itself, so only the success
+			// path is expected to skip writing one
+			if tt.wantStatus == http.StatusOK && head.Body.Len() != 0 {
+				t.Errorf("HEAD wrote a body: %s", head.Body)
+			}
+			for _, name := range []string{"Content-Type", "Content-Length", "ETag"} {
+				if g, h := get.Header().Get(name), head.Header().Get(name); g != h {
+					t.Errorf("%s: GET %q, HEAD %q", name, g, h)
+				}
+			}
+			if tt.wantStatus == http.StatusOK && get.Header().Get("Content-Length") != strconv.Itoa(get.Body.Len()) {
+				t.Errorf("Content-Length %s for a %d byte body", get.Header().Get("Content-Length"), get.Body.Len())
+			}
+		})
+	}
+}
+
+func TestStreamAccountHandler(t *testing.T) {
+	store := NewMapStore()
+	large := Account{ID: "1", Name: strings.Repeat("x", 1<<20), Balance: 250}
+	store.Put(context.Background(), large)
+	r := mux.NewRouter()
+	r.Handle("/account/{id}", StreamAccountHandler(store))
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	tests := []struct {
+		name        string
+		id          string
+		wantStatus  int
+		wantChunked bool
+	}{
+		{"large account", "1", http.StatusOK, true},
+		{"missing account", "2", http.StatusNotFound, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(srv.URL + "/account/" + tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			chunked := len(resp.TransferEncoding) > 0 && resp.TransferEncoding[0] == "chunked"
+			if chunked != tt.wantChunked {
+				t.Errorf("chunked = %v (Content-Length %d), want %v", chunked, resp.ContentLength, tt.wantChunked)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var body struct {
+				Data Account `json:"data"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Data != large {
+				t.Error("streamed account does not match the stored one")
+			}
+			if resp.Header.Get("ETag") != accountETag(large) {
+				t.Errorf("ETag = %q, want %q", resp.Header.Get("ETag"), accountETag(large))
+			}
+		})
+	}
+}
+
+func TestPutAccountIfMatch(t *testing.T) {
+	current := Account{ID: "1", Name: "a", Balance: 100}
+	stale := Account{ID: "1", Name: "old"}
+	tests := []struct {
+		name           string
+		requireIfMatch bool
+		ifMatch        string
+		wantStatus     int
+		wantName       string
+	}{
+		{"matching version", true, accountETag(current), http.StatusOK, "b"},
+		{"matching in a list", true, `"nope", ` + accountETag(current), http.StatusOK, "b"},
+		{"wildcard", true, "*", http.StatusOK, "b"},
+		{"stale version", true, accountETag(stale), http.StatusPreconditionFailed, "a"},
+		{"missing header", true, "", http.StatusPreconditionRequired, "a"},
+		{"missing header allowed", false, "", http.StatusOK, "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMapStore()
+			store.Put(context.Background(), current)
+			r := mux.NewRouter()
+			r.Handle("/account/{id}", PutAccount(store, tt.requireIfMatch))
+
+			req := httptest.NewRequest(http.MethodPut, "/account/1", strings.NewReader(`{"name":"b","balance":"1.00"}`))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			got, _ := store.Get(context.Background(), "1")
+			if got.Name != tt.wantName {
+				t.Errorf("stored name = %q, want %q", got.Name, tt.wantName)
+			}
+			if tt.wantStatus == http.StatusOK && rec.Header().Get("ETag") != accountETag(got) {
+				t.Errorf("ETag = %q, want the new version %q", rec.Header().Get("ETag"), accountETag(got))
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Decision is the outcome of an authorization check
+type Decision string
+
+const (
+	DecisionAllow Decision = "allow"
+	DecisionDeny  Decision = "deny"
+)
+
+// AuditEvent records one authorization decision
+type AuditEvent struct {
+	Time     time.Time `json:"time"`
+	ClientIP string    `json:"client_ip"`
+	Path     string    `json:"path"`
+	Route    string    `json:"route"`
+	Subject  string    `json:"subject,omitempty"` // empty when authentication failed
+	Decision Decision  `json:"decision"`
+	Reason   string    `json:"reason"`
+}
+
+// AuditSink receives audit events. Record is called on the request path, so
+// it should not block for long.
+type AuditSink interface {
+	Record(AuditEvent)
+}
+
+// NopAuditSink discards every event
+type NopAuditSink struct{}
+
+func (NopAuditSink) Record(AuditEvent) {}
+
+// JSONLinesSink writes each event as one line of JSON
+type JSONLinesSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	c   io.Closer
+}
+
+// NewJSONLinesSink writes events to w
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{enc: json.NewEncoder(w)}
+}
+
+// OpenAuditFile appends events to the file at path, creating it if needed
+func OpenAuditFile(path string) (*JSONLinesSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	s := NewJSONLinesSink(f)
+	s.c = f
+	return s, nil
+}
+
+func (s *JSONLinesSink) Record(e AuditEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.enc.Encode(e); err != nil {
+		fmt.Println("audit: write failed:", err)
+	}
+}
+
+// Close closes the underlying file, if the sink opened one
+func (s *JSONLinesSink) Close() error {
+	if s.c == nil {
+		return nil
+	}
+	return s.c.Close()
+}
+
+// audit sends an event for req to cfg.Audit
+func (cfg AuthConfig) audit(req *http.Request, subject string, d Decision, reason string) {
+	if cfg.Audit == nil {
+		return
+	}
+	cfg.Audit.Record(AuditEvent{
+		Time:     time.Now().UTC(),
+		ClientIP: clientIP(req),
+		Path:     req.URL.Path,
+		Route:    RouteTemplate(req.Context()),
+		Subject:  subject,
+		Decision: d,
+		Reason:   reason,
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// captureSink keeps every event it records
+type captureSink struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (s *captureSink) Record(e AuditEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, e)
+}
+
+func TestAuthAudit(t *testing.T) {
+	tests := []struct {
+		name        string
+		auth        string
+		wantSubject string
+		wantDecided Decision
+		wantReason  string
+	}{
+		{"allow", "1", "1", DecisionAllow, "owner"},
+		{"deny other owner", "2", "2", DecisionDeny, "ownership not matched"},
+		{"deny missing token", "", "", DecisionDeny, "missing auth token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &captureSink{}
+			r := mux.NewRouter()
+			r.Handle("/account/{id}", AccountAuthMiddleware(AuthConfig{Audit: sink})(http.HandlerFunc(Healthz)))
+			req := httptest.NewRequest(http.MethodGet, "/account/1", nil)
+			req.RemoteAddr = "203.0.113.7:5555"
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			captureStdout(t, func() { r.ServeHTTP(httptest.NewRecorder(), req) })
+
+			if len(sink.events) != 1 {
+				t.Fatalf("recorded %d events, want 1: %+v", len(sink.events), sink.events)
+			}
+			e := sink.events[0]
+			if e.Subject != tt.wantSubject || e.Decision != tt.wantDecided || e.Reason != tt.wantReason {
+				t.Errorf("event = %+v, want subject %q decision %s reason %q", e, tt.wantSubject, tt.wantDecided, tt.wantReason)
+			}
+			if e.Path != "/account/1" || e.ClientIP != "203.0.113.7" || e.Time.IsZero() {
+				t.Errorf("event = %+v", e)
+			}
+		})
+	}
+}
+
+func TestOpenAuditFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	events := []AuditEvent{
+		{Path: "/account/1", Subject: "1", Decision: DecisionAllow, Reason: "owner"},
+		{Path: "/account/1", Decision: DecisionDeny, Reason: "missing auth token"},
+	}
+	for i := 0; i < 2; i++ { // reopening appends
+		sink, err := OpenAuditFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sink.Record(events[i])
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []AuditEvent
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		var e AuditEvent
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		got = append(got, e)
+	}
+	if len(got) != len(events) {
+		t.Fatalf("read %d events, want %d", len(got), len(events))
+	}
+	for i := range events {
+		if got[i] != events[i] {
+			t.Errorf("event %d = %+v, want %+v", i, got[i], events[i])
+		}
+	}
+}
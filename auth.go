@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// PasswordVerifier checks the credentials presented via HTTP Basic auth
+type PasswordVerifier interface {
+	VerifyPassword(username, password string) bool
+}
+
+// PasswordVerifierFunc adapts a plain function to a PasswordVerifier
+type PasswordVerifierFunc func(username, password string) bool
+
+func (f PasswordVerifierFunc) VerifyPassword(username, password string) bool {
+	return f(username, password)
+}
+
+// AuthConfig selects which credential schemes AccountAuthMiddleware accepts
+type AuthConfig struct {
+	// Tokens verifies "Authorization: Bearer <jwt>"; nil disables Bearer
+	Tokens *TokenIssuer
+
+	// Passwords verifies "Authorization: Basic ..."; nil disables Basic
+	Passwords PasswordVerifier
+
+	// RejectDuplicateHeaders answers 400 when a request carries more than
+	// one Authorization header instead of silently using the first, as
+	// RejectDuplicateHeaders("Authorization") would in front of the auth
+	// middleware. Leave it off for proxies that legitimately repeat the header.
+	RejectDuplicateHeaders bool
+
+	// TokenCookie names a cookie read for the token when the request has
+	// no Authorization header, for browsers holding it in an HttpOnly
+	// cookie. It is checked as a Bearer token when Tokens is set and as
+	// the plain account id otherwise. Empty disables the fallback.
+	TokenCookie string
+
+	// Revoked, if set, rejects Bearer tokens whose jti it holds
+	Revoked *RevocationStore
+
+	// Validators run, in order, on the claims of every Bearer token that
+	// verified, e.g. RequireAudience and RequireIssuer. The first error
+	// rejects the token with a 401.
+	Validators []ClaimValidator
+
+	// Audit receives one event per request recording whether it was
+	// allowed and why; nil discards them
+	Audit AuditSink
+
+	// RequireTenant rejects with a 403 callers not bound to the tenant
+	// TenantMiddleware stored in the request context. RegisterTenantRoutes
+	// sets it.
+	RequireTenant bool
+}
+
+// Realm advertised to Basic auth clients
+const basicChallenge = `Basic realm="account"`
+
+// AuthenticateMiddleware verifies the caller's credentials and stores the
+// resulting Principal in the request context. The scheme is picked from the
+// Authorization header: "Basic" takes the username as the account id,
+// "Bearer" takes the token subject, and anything else is the legacy plain id,
+// with or without a "Bearer " prefix.
+func AuthenticateMiddleware(cfg AuthConfig) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if abandoned(req) {
+				return
+			}
+			principal, ok := cfg.principal(w, req)
+			if !ok {
+				return
+			}
+			cfg.audit(req, principal.ID, DecisionAllow, "authenticated")
+			next.ServeHTTP(w, req.WithContext(WithPrincipal(req.Context(), principal)))
+		})
+	}
+}
+
+// AccountAuthMiddleware authenticates the caller like AuthenticateMiddleware
+// and additionally checks they own the {id} in the path
+func AccountAuthMiddleware(cfg AuthConfig) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if abandoned(req) {
+				return
+			}
+			principal, ok := cfg.principal(w, req)
+			if !ok {
+				return
+			}
+			tokenID := mux.Vars(req)["id"]
+			if principal.ID != tokenID {
+				fmt.Println("ownership not matched")
+				cfg.audit(req, principal.ID, DecisionDeny, "ownership not matched")
+				writeError(w, &APIError{HTTPStatus: http.StatusForbidden, ErrorCode: "forbidden", Message: "ownership not matched", Err: ErrForbidden})
+				return
+			}
+			cfg.audit(req, principal.ID, DecisionAllow, "owner")
+			next.ServeHTTP(w, req.WithContext(WithPrincipal(req.Context(), principal)))
+		})
+	}
+}
+
+// principal authenticates req and, under RequireTenant, checks the caller's
+// tenant, writing the error response itself when either fails. Either way
+// the decision is audited.
+func (cfg AuthConfig) principal(w http.ResponseWriter, req *http.Request) (*Principal, bool) {
+	principal, apiErr := cfg.check(w, req)
+	if apiErr != nil {
+		fmt.Println(apiErr.Message)
+		cfg.audit(req, "", DecisionDeny, apiErr.Message)
+		writeError(w, apiErr)
+		return nil, false
+	}
+	if cfg.RequireTenant {
+		if t, ok := TenantFromContext(req.Context()); !ok || principal.Tenant != t.ID {
+			fmt.Println("tenant not matched")
+			cfg.audit(req, principal.ID, DecisionDeny, "tenant not matched")
+			writeError(w, &APIError{HTTPStatus: http.StatusForbidden, ErrorCode: "forbidden", Message: "tenant not matched", Err: ErrForbidden})
+			return nil, false
+		}
+	}
+	return principal, true
+}
+
+// check resolves the caller, or returns the error to answer with. Any
+// challenge headers are set on w.
+func (cfg AuthConfig) check(w http.ResponseWriter, req *http.Request) (*Principal, *APIError) {
+	if cfg.RejectDuplicateHeaders {
+		if apiErr := duplicateHeader(req, "Authorization"); apiErr != nil {
+			return nil, apiErr
+		}
+	}
+	profile := cfg.credentials(req)
+	if len(profile) == 0 {
+		return nil, cfg.unauthorized(w, "missing auth token")
+	}
+	principal, err := cfg.authenticate(req, profile)
+	var claimErr *claimError
+	switch {
+	case errors.Is(err, ErrTokenExpired):
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="expired"`)
+		return nil, &APIError{HTTPStatus: http.StatusUnauthorized, ErrorCode: "token_expired", Message: "token expired", Err: err}
+	case errors.Is(err, ErrTokenRevoked):
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="revoked"`)
+		return nil, &APIError{HTTPStatus: http.StatusUnauthorized, ErrorCode: "invalid_token", Message: "token revoked", Err: err}
+	case errors.As(err, &claimErr):
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="invalid_token", error_description=%q`, claimErr.Error()))
+		return nil, &APIError{HTTPStatus: http.StatusUnauthorized, ErrorCode: "invalid_token", Message: claimErr.Error(), Err: err}
+	case errors.Is(err, ErrInvalidToken):
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		return nil, &APIError{HTTPStatus: http.StatusUnauthorized, ErrorCode: "invalid_token", Message: "invalid token", Err: err}
+	case err != nil:
+		return nil, cfg.unauthorized(w, err.Error())
+	}
+	return principal, nil
+}
+
+// credentials returns the Authorization header, falling back to the token
+// cookie rewritten into header form
+func (cfg AuthConfig) credentials(req *http.Request) string {
+	if profile := req.Header.Get("Authorization"); profile != "" || cfg.TokenCookie == "" {
+		return profile
+	}
+	c, err := req.Cookie(cfg.TokenCookie)
+	if err != nil || c.Value == "" {
+		return ""
+	}
+	if cfg.Tokens != nil {
+		return "Bearer " + c.Value
+	}
+	return c.Value
+}
+
+// authenticate resolves who the Authorization header speaks for
+func (cfg AuthConfig) authenticate(req *http.Request, profile string) (*Principal, error) {
+	scheme, rest := splitScheme(profile)
+	switch {
+	case strings.EqualFold(scheme, "Basic"):
+		if cfg.Passwords == nil {
+			return nil, fmt.Errorf("basic auth not accepted")
+		}
+		user, pass, ok := req.BasicAuth()
+		if !ok || !cfg.Passwords.VerifyPassword(user, pass) {
+			return nil, fmt.Errorf("invalid credentials")
+		}
+		return &Principal{ID: user}, nil
+	case strings.EqualFold(scheme, "Bearer") && cfg.Tokens != nil:
+		claims, err := cfg.Tokens.Parse(rest)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Revoked != nil && cfg.Revoked.IsRevoked(claims.ID) {
+			return nil, ErrTokenRevoked
+		}
+		for _, validate := range cfg.Validators {
+			if err := validate(claims); err != nil {
+				return nil, &claimError{err}
+			}
+		}
+		return &Principal{ID: claims.Subject, Roles: claims.Roles, Tenant: claims.Tenant, TokenID: claims.ID}, nil
+	default:
+		return &Principal{ID: stripBearer(profile)}, nil
+	}
+}
+
+// stripBearer removes a case-insensitive "Bearer " prefix from an
+// Authorization value, leaving bare tokens as they are
+func stripBearer(profile string) string {
+	if scheme, rest := splitScheme(profile); strings.EqualFold(scheme, "Bearer") {
+		return rest
+	}
+	return profile
+}
+
+// unauthorized builds a 401, challenging for Basic credentials when enabled
+func (cfg AuthConfig) unauthorized(w http.ResponseWriter, msg string) *APIError {
+	if cfg.Passwords != nil {
+		w.Header().Set("WWW-Authenticate", basicChallenge)
+	}
+	return &APIError{HTTPStatus: http.StatusUnauthorized, ErrorCode: "unauthorized", Message: msg, Err: ErrUnauthorized}
+}
+
+// splitScheme splits "Scheme credentials" into its two parts
+func splitScheme(header string) (scheme, rest string) {
+	i := strings.IndexByte(header, ' ')
+	if i < 0 {
+		return "", header
+	}
+	return header[:i], strings.TrimSpace(header[i+1:])
+}
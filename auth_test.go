@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-rest-api-example/testutil"
+
+	"github.com/gorilla/mux"
+)
+
+// serveAuth runs req through AuthenticateMiddleware(cfg) and returns the
+// response along with the principal id the handler saw, if it ran
+func serveAuth(cfg AuthConfig, req *http.Request) (*httptest.ResponseRecorder, string) {
+	rec, next := testutil.InvokeMiddleware(AuthenticateMiddleware(cfg), req)
+	if next == nil {
+		return rec, ""
+	}
+	p, _ := PrincipalFromContext(next.Context())
+	return rec, p.ID
+}
+
+func TestAuthenticateMiddlewareSchemes(t *testing.T) {
+	issuer := &TokenIssuer{Secret: []byte("secret"), TTL: time.Hour}
+	bearer, err := issuer.Issue("7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	passwords := PasswordVerifierFunc(func(user, pass string) bool { return user == "5" && pass == "pw" })
+	basic := func(user, pass string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+	}
+	tests := []struct {
+		name          string
+		cfg           AuthConfig
+		header        string
+		wantStatus    int
+		wantID        string
+		wantChallenge string
+	}{
+		{"basic valid", AuthConfig{Passwords: passwords}, basic("5", "pw"), http.StatusOK, "5", ""},
+		{"basic wrong password", AuthConfig{Passwords: passwords}, basic("5", "nope"), http.StatusUnauthorized, "", basicChallenge},
+		{"basic malformed", AuthConfig{Passwords: passwords}, "Basic !!!", http.StatusUnauthorized, "", basicChallenge},
+		{"basic missing", AuthConfig{Passwords: passwords}, "", http.StatusUnauthorized, "", basicChallenge},
+		{"basic not accepted", AuthConfig{}, basic("5", "pw"), http.StatusUnauthorized, "", ""},
+		{"bearer valid", AuthConfig{Tokens: issuer, Passwords: passwords}, "Bearer " + bearer, http.StatusOK, "7", ""},
+		{"bearer invalid", AuthConfig{Tokens: issuer}, "Bearer junk", http.StatusUnauthorized, "", `Bearer error="invalid_token"`},
+		{"plain id", AuthConfig{}, "9", http.StatusOK, "9", ""},
+		{"plain id with bearer prefix", AuthConfig{}, "Bearer 9", http.StatusOK, "9", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec, id := serveAuth(tt.cfg, req)
+			testutil.AssertStatus(t, rec, tt.wantStatus)
+			if id != tt.wantID {
+				t.Errorf("principal = %q, want %q", id, tt.wantID)
+			}
+			if got := rec.Header().Get("WWW-Authenticate"); got != tt.wantChallenge {
+				t.Errorf("WWW-Authenticate = %q, want %q", got, tt.wantChallenge)
+			}
+		})
+	}
+}
+
+func TestAuthenticateMiddlewareDuplicateAuthorization(t *testing.T) {
+	tests := []struct {
+		name       string
+		reject     bool
+		values     []string
+		wantStatus int
+		wantID     string
+	}{
+		{"single header", true, []string{"7"}, http.StatusOK, "7"},
+		{"duplicate rejected", true, []string{"7", "8"}, http.StatusBadRequest, ""},
+		{"duplicate allowed", false, []string{"7", "8"}, http.StatusOK, "7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, v := range tt.values {
+				req.Header.Add("Authorization", v)
+			}
+			rec, id := serveAuth(AuthConfig{RejectDuplicateHeaders: tt.reject}, req)
+			testutil.AssertStatus(t, rec, tt.wantStatus)
+			if id != tt.wantID {
+				t.Errorf("principal = %q, want %q", id, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestAuthenticateMiddlewareTokenErrors(t *testing.T) {
+	clock := newFakeClock(time.Unix(1000, 0))
+	issuer := &TokenIssuer{Secret: []byte("secret"), TTL: time.Hour, Clock: clock}
+	forger := &TokenIssuer{Secret: []byte("other"), TTL: time.Hour, Clock: clock}
+	tests := []struct {
+		name          string
+		issuer        *TokenIssuer
+		age           time.Duration
+		wantStatus    int
+		wantCode      string
+		wantChallenge string
+	}{
+		{"valid", issuer, 0, http.StatusOK, "", ""},
+		{"expired", issuer, 2 * time.Hour, http.StatusUnauthorized, "token_expired", `Bearer error="invalid_token", error_description="expired"`},
+		{"bad signature", forger, 0, http.StatusUnauthorized, "invalid_token", `Bearer error="invalid_token"`},
+		{"bad signature and expired", forger, 2 * time.Hour, http.StatusUnauthorized, "invalid_token", `Bearer error="invalid_token"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := tt.issuer.Issue("7")
+			if err != nil {
+				t.Fatal(err)
+			}
+			clock.Advance(tt.age)
+			defer clock.Advance(-tt.age)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec, _ := serveAuth(AuthConfig{Tokens: issuer}, req)
+			testutil.AssertStatus(t, rec, tt.wantStatus)
+			if got := decodeErrorCode(t, rec); got != tt.wantCode {
+				t.Errorf("error = %q, want %q", got, tt.wantCode)
+			}
+			if got := rec.Header().Get("WWW-Authenticate"); got != tt.wantChallenge {
+				t.Errorf("WWW-Authenticate = %q, want %q", got, tt.wantChallenge)
+			}
+		})
+	}
+}
+
+func TestAuthenticateMiddlewareTokenCookie(t *testing.T) {
+	issuer := &TokenIssuer{Secret: []byte("secret"), TTL: time.Hour}
+	cookieToken, err := issuer.Issue("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	headerToken, err := issuer.Issue("h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		cfg        AuthConfig
+		header     string
+		cookie     string
+		wantStatus int
+		wantID     string
+	}{
+		{"cookie only", AuthConfig{Tokens: issuer, TokenCookie: "session"}, "", cookieToken, http.StatusOK, "c"},
+		{"header only", AuthConfig{Tokens: issuer, TokenCookie: "session"}, "Bearer " + headerToken, "", http.StatusOK, "h"},
+		{"header wins", AuthConfig{Tokens: issuer, TokenCookie: "session"}, "Bearer " + headerToken, cookieToken, http.StatusOK, "h"},
+		{"neither", AuthConfig{Tokens: issuer, TokenCookie: "session"}, "", "", http.StatusUnauthorized, ""},
+		{"cookie not configured", AuthConfig{Tokens: issuer}, "", cookieToken, http.StatusUnauthorized, ""},
+		{"plain id cookie", AuthConfig{TokenCookie: "session"}, "", "9", http.StatusOK, "9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "session", Value: tt.cookie})
+			}
+			rec, id := serveAuth(tt.cfg, req)
+			testutil.AssertStatus(t, rec, tt.wantStatus)
+			if id != tt.wantID {
+				t.Errorf("principal = %q, want %q", id, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestAuthenticateMiddlewareClaimValidators(t *testing.T) {
+	validators := []ClaimValidator{RequireAudience("api"), RequireIssuer("auth.example")}
+	tests := []struct {
+		name          string
+		issuer        string
+		audience      []string
+		wantStatus    int
+		wantChallenge string
+	}{
+		{"both pass", "auth.example", []string{"web", "api"}, http.StatusOK, ""},
+		{"wrong audience", "auth.example", []string{"web"}, http.StatusUnauthorized, `Bearer error="invalid_token", error_description="audience must include \"api\""`},
+		{"no audience", "auth.example", nil, http.StatusUnauthorized, `Bearer error="invalid_token", error_description="audience must include \"api\""`},
+		{"wrong issuer", "other.example", []string{"api"}, http.StatusUnauthorized, `Bearer error="invalid_token", error_description="issuer must be \"auth.example\""`},
+		{"first failure reported", "other.example", nil, http.StatusUnauthorized, `Bearer error="invalid_token", error_description="audience must include \"api\""`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := &TokenIssuer{Secret: []byte("secret"), TTL: time.Hour, Issuer: tt.issuer, Audience: tt.audience}
+			token, err := issuer.Issue("7")
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec, _ := serveAuth(AuthConfig{Tokens: issuer, Validators: validators}, req)
+			testutil.AssertStatus(t, rec, tt.wantStatus)
+			if got := rec.Header().Get("WWW-Authenticate"); got != tt.wantChallenge {
+				t.Errorf("WWW-Authenticate = %q, want %q", got, tt.wantChallenge)
+			}
+		})
+	}
+}
+
+func TestBearerPrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"Bearer", "Bearer 123", http.StatusOK},
+		{"lowercase bearer", "bearer 123", http.StatusOK},
+		{"uppercase BEARER", "BEARER 123", http.StatusOK},
+		{"raw token", "123", http.StatusOK},
+		{"bearer other id", "Bearer 124", http.StatusForbidden},
+		{"other scheme kept", "Token 123", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mux.NewRouter()
+			r.Handle("/account/{id}", AccountAuthMiddleware(AuthConfig{})(http.HandlerFunc(GetAccount)))
+			req := httptest.NewRequest(http.MethodGet, "/account/123", nil)
+			req.Header.Set("Authorization", tt.header)
+			rec := httptest.NewRecorder()
+			captureStdout(t, func() { r.ServeHTTP(rec, req) })
+			testutil.AssertStatus(t, rec, tt.want)
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ErrBodyReadTimeout is returned by request body reads once the upload has
+// used up its time budget
+var ErrBodyReadTimeout = errors.New("request body read timed out")
+
+// Head start a body gets before MinBytesPerSecond is enforced, so a slow
+// first packet isn't mistaken for a trickle
+const bodyRateGrace = time.Second
+
+// BodyReadLimits bounds how long reading a request body may take. A zero
+// field is not enforced.
+type BodyReadLimits struct {
+	// MaxDuration caps the time from the first read to the end of the body
+	MaxDuration time.Duration
+	// MinBytesPerSecond is the slowest average upload rate tolerated
+	MinBytesPerSecond int
+}
+
+// BodyReadTimeoutMiddleware stops clients holding a handler open by
+// trickling a body. Once a read would run past limits the body returns
+// ErrBodyReadTimeout, which the decoding helpers answer with a 408, and the
+// connection is marked to close. This guards uploads only; the handler's
+// own run time is GlobalTimeoutMiddleware's concern.
+func BodyReadTimeoutMiddleware(limits BodyReadLimits) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if limits.MaxDuration <= 0 && limits.MinBytesPerSecond <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if hasBody(req) {
+				req.Body = &deadlineBody{body: req.Body, limits: limits, w: w}
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+type readResult struct {
+	buf []byte
+	err error
+}
+
+// deadlineBody enforces BodyReadLimits on a request body. Reads happen on a
+// separate goroutine into a private buffer, so a read abandoned at the
+// deadline can't write into the caller's slice afterwards.
+type deadlineBody struct {
+	body   io.ReadCloser
+	limits BodyReadLimits
+	w      http.ResponseWriter
+
+	start   time.Time
+	read    int64
+	pending chan readResult // read started by an earlier call, if any
+	err     error
+}
+
+// deadline is when the next read must have returned by
+func (b *deadlineBody) deadline() time.Time {
+	var d time.Time
+	if b.limits.MaxDuration > 0 {
+		d = b.start.Add(b.limits.MaxDuration)
+	}
+	if rate := int64(b.limits.MinBytesPerSecond); rate > 0 {
+		byRate := b.start.Add(bodyRateGrace + time.Duration(b.read*int64(time.Second)/rate))
+		if d.IsZero() || byRate.Before(d) {
+			d = byRate
+		}
+	}
+	return d
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if b.start.IsZero() {
+		b.start = time.Now()
+	}
+	if b.pending == nil {
+		ch := make(chan readResult, 1)
+		buf := make([]byte, len(p))
+		go func() {
+			n, err := b.body.Read(buf)
+			ch <- readResult{buf: buf[:n], err: err}
+		}()
+		b.pending = ch
+	}
+
+	t := time.NewTimer(time.Until(b.deadline()))
+	defer t.Stop()
+	select {
+	case res := <-b.pending:
+		b.pending = nil
+		n := copy(p, res.buf)
+		b.read += int64(n)
+		return n, res.err
+	case <-t.C:
+		b.err = ErrBodyReadTimeout
+		b.w.Header().Set("Connection", "close")
+		return 0, b.err
+	}
+}
+
+func (b *deadlineBody) Close() error {
+	return b.body.Close()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowReader returns its chunks one per Read, sleeping delay before each
+type slowReader struct {
+	chunks []string
+	delay  time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(r.delay)
+	n := copy(p, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
+
+func TestBodyReadTimeoutMiddleware(t *testing.T) {
+	body := []string{`{"account_id":`, `"1",`, `"name":`, `"a"}`}
+	tests := []struct {
+		name   string
+		limits BodyReadLimits
+		delay  time.Duration // before each chunk of the body
+		want   int
+	}{
+		{"slow but within budget", BodyReadLimits{MaxDuration: time.Second}, 10 * time.Millisecond, http.StatusOK},
+		{"over max duration", BodyReadLimits{MaxDuration: 50 * time.Millisecond}, 30 * time.Millisecond, http.StatusRequestTimeout},
+		{"under min rate", BodyReadLimits{MinBytesPerSecond: 1000}, 400 * time.Millisecond, http.StatusRequestTimeout},
+		{"no limits", BodyReadLimits{}, 30 * time.Millisecond, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := BodyReadTimeoutMiddleware(tt.limits)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				var a Account
+				if err := decodeJSON(req, &a); err != nil {
+					writeError(w, err)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/account", &slowReader{chunks: body, delay: tt.delay})
+			req.ContentLength = int64(len(strings.Join(body, "")))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if closing := rec.Header().Get("Connection") == "close"; closing != (tt.want == http.StatusRequestTimeout) {
+				t.Errorf("Connection: close set = %v", closing)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by BreakerStore while it is failing fast
+var ErrCircuitOpen = errors.New("circuit open")
+
+// circuitOpenError is the concrete ErrCircuitOpen, carrying how long the
+// caller should wait before retrying
+type circuitOpenError struct {
+	retryAfter time.Duration
+}
+
+func (e *circuitOpenError) Error() string        { return ErrCircuitOpen.Error() }
+func (e *circuitOpenError) Is(target error) bool { return target == ErrCircuitOpen }
+
+// BreakerConfig tunes BreakerStore
+type BreakerConfig struct {
+	// Consecutive store errors that trip the breaker
+	Threshold int
+	// How long the breaker stays open before letting a probe through
+	Cooldown time.Duration
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// BreakerStore wraps an AccountStore with a circuit breaker. After
+// Threshold consecutive failures every call fails fast with ErrCircuitOpen
+// until Cooldown passes; then a single probe call is let through, and its
+// outcome decides whether the breaker closes or opens again. ErrNotFound
+// and ErrAlreadyExists are normal answers, not failures.
+type BreakerStore struct {
+	store AccountStore
+	cfg   BreakerConfig
+	now   func() time.Time
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+func NewBreakerStore(store AccountStore, cfg BreakerConfig) *BreakerStore {
+	return &BreakerStore{store: store, cfg: cfg, now: time.Now}
+}
+
+// allow reports whether a call may go through to the store, and if not,
+// the error to fail fast with
+func (b *BreakerStore) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if wait := b.cfg.Cooldown - b.now().Sub(b.openedAt); wait > 0 {
+			return &circuitOpenError{retryAfter: wait}
+		}
+		b.state = breakerHalfOpen
+	case breakerHalfOpen:
+		// a probe is already in flight
+		return &circuitOpenError{retryAfter: b.cfg.Cooldown}
+	}
+	return nil
+}
+
+func (b *BreakerStore) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil || errors.Is(err, ErrNotFound) || errors.Is(err, ErrAlreadyExists) {
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.cfg.Threshold {
+		b.state = breakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+func (b *BreakerStore) Get(ctx context.Context, id string) (Account, error) {
+	if err := b.allow(); err != nil {
+		return Account{}, err
+	}
+	a, err := b.store.Get(ctx, id)
+	b.record(err)
+	return a, err
+}
+
+func (b *BreakerStore) Put(ctx context.Context, a Account) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := b.store.Put(ctx, a)
+	b.record(err)
+	return err
+}
+
+func (b *BreakerStore) Create(ctx context.Context, a Account) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := b.store.Create(ctx, a)
+	b.record(err)
+	return err
+}
+
+func (b *BreakerStore) Update(ctx context.Context, id string, fn func(*Account) error) (Account, error) {
+	if err := b.allow(); err != nil {
+		return Account{}, err
+	}
+	a, err := b.store.Update(ctx, id, fn)
+	b.record(err)
+	return a, err
+}
+
+func (b *BreakerStore) List(ctx context.Context, limit int, cursor string) ([]Account, string, error) {
+	if err := b.allow(); err != nil {
+		return nil, "", err
+	}
+	items, next, err := b.store.List(ctx, limit, cursor)
+	b.record(err)
+	return items, next, err
+}
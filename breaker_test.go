@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// flakyStore fails every Get while fail is set, counting the calls that
+// reach it
+type flakyStore struct {
+	*MapStore
+	fail  bool
+	calls int
+}
+
+func (s *flakyStore) Get(ctx context.Context, id string) (Account, error) {
+	s.calls++
+	if s.fail {
+		return Account{}, errors.New("store down")
+	}
+	return s.MapStore.Get(ctx, id)
+}
+
+// breakerStep is one Get through the breaker
+type breakerStep struct {
+	advance   time.Duration
+	fail      bool  // whether the backing store is failing
+	wantErr   error // nil, ErrCircuitOpen, or any other error as errStoreDown
+	wantCalls int   // backing store calls so far
+}
+
+var errStoreDown = errors.New("any store error")
+
+func TestBreakerStore(t *testing.T) {
+	cfg := BreakerConfig{Threshold: 2, Cooldown: time.Minute}
+	tests := []struct {
+		name  string
+		steps []breakerStep
+	}{
+		{"stays closed below threshold", []breakerStep{
+			{fail: true, wantErr: errStoreDown, wantCalls: 1},
+			{wantCalls: 2},
+			{fail: true, wantErr: errStoreDown, wantCalls: 3},
+			{wantCalls: 4},
+		}},
+		{"opens and fails fast", []breakerStep{
+			{fail: true, wantErr: errStoreDown, wantCalls: 1},
+			{fail: true, wantErr: errStoreDown, wantCalls: 2},
+			{wantErr: ErrCircuitOpen, wantCalls: 2},
+			{advance: 59 * time.Second, wantErr: ErrCircuitOpen, wantCalls: 2},
+		}},
+		{"half-open probe recovers", []breakerStep{
+			{fail: true, wantErr: errStoreDown, wantCalls: 1},
+			{fail: true, wantErr: errStoreDown, wantCalls: 2},
+			{advance: time.Minute, wantCalls: 3},
+			{wantCalls: 4},
+		}},
+		{"failed probe reopens", []breakerStep{
+			{fail: true, wantErr: errStoreDown, wantCalls: 1},
+			{fail: true, wantErr: errStoreDown, wantCalls: 2},
+			{advance: time.Minute, fail: true, wantErr: errStoreDown, wantCalls: 3},
+			{wantErr: ErrCircuitOpen, wantCalls: 3},
+			{advance: time.Minute, wantCalls: 4},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := &flakyStore{MapStore: NewMapStore()}
+			inner.Put(context.Background(), Account{ID: "1"})
+			clock := newFakeClock(time.Unix(0, 0))
+			b := NewBreakerStore(inner, cfg)
+			b.now = clock.Now
+
+			for i, step := range tt.steps {
+				clock.Advance(step.advance)
+				inner.fail = step.fail
+				_, err := b.Get(context.Background(), "1")
+				switch {
+				case step.wantErr == nil && err != nil,
+					step.wantErr == ErrCircuitOpen && !errors.Is(err, ErrCircuitOpen),
+					step.wantErr == errStoreDown && (err == nil || errors.Is(err, ErrCircuitOpen)):
+					t.Fatalf("step %d: err = %v, want %v", i, err, step.wantErr)
+				}
+				if inner.calls != step.wantCalls {
+					t.Fatalf("step %d: store calls = %d, want %d", i, inner.calls, step.wantCalls)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerStoreNotFoundIsNotFailure(t *testing.T) {
+	b := NewBreakerStore(NewMapStore(), BreakerConfig{Threshold: 1, Cooldown: time.Minute})
+	for i := 0; i < 3; i++ {
+		if _, err := b.Get(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("call %d: err = %v, want ErrNotFound", i, err)
+		}
+	}
+}
+
+func TestGetAccountCircuitOpen(t *testing.T) {
+	inner := &flakyStore{MapStore: NewMapStore(), fail: true}
+	clock := newFakeClock(time.Unix(0, 0))
+	b := NewBreakerStore(inner, BreakerConfig{Threshold: 1, Cooldown: 30 * time.Second})
+	b.now = clock.Now
+	b.Get(context.Background(), "1")
+	clock.Advance(10 * time.Second)
+
+	r := mux.NewRouter()
+	r.Handle("/account/{id}", GetAccountHandler(b))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/account/1", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "20" {
+		t.Errorf("Retry-After = %q, want 20", got)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Longest NDJSON line BulkImportHandler accepts, in bytes
+const maxBulkLine = 1 << 20 // 1MB
+
+// bulkResult reports the outcome of one line of a bulk import
+type bulkResult struct {
+	Line      int               `json:"line"`
+	AccountID string            `json:"account_id,omitempty"`
+	OK        bool              `json:"ok"`
+	Status    int               `json:"status,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+}
+
+// BulkImportHandler stores the accounts in an NDJSON request body, one per
+// line, as it reads them, so the body is never held in memory whole. The
+// response is NDJSON too, one result per non-blank line. Over HTTP/2 each
+// result is flushed as soon as it is known. HTTP/1 servers stop reading the
+// body once the response starts, so there the results are held back until
+// the body is done. A malformed or invalid line is reported and skipped
+// without stopping the import; an unreadable body or a cancelled request
+// does stop it, after the lines already handled. Lines are created, never
+// overwritten: a taken id fails with 409. Callers may only import their own
+// account unless they are admins; other lines fail with 403.
+func BulkImportHandler(store AccountStore) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/x-ndjson")
+		var held bytes.Buffer
+		enc := json.NewEncoder(&held)
+		flush := func() {}
+		if f, ok := rw.(http.Flusher); ok && req.ProtoMajor >= 2 {
+			rw.WriteHeader(http.StatusOK)
+			enc = json.NewEncoder(rw)
+			flush = f.Flush
+		}
+		defer func() {
+			if held.Len() > 0 {
+				rw.Write(held.Bytes())
+			}
+		}()
+
+		sc := bufio.NewScanner(req.Body)
+		sc.Buffer(make([]byte, 0, 64<<10), maxBulkLine)
+		line := 0
+		for sc.Scan() {
+			line++
+			if req.Context().Err() != nil {
+				return
+			}
+			if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+				continue
+			}
+			enc.Encode(importLine(req, store, line, sc.Bytes()))
+			flush()
+		}
+		if err := sc.Err(); err != nil {
+			fmt.Println("bulk import:", err)
+			enc.Encode(bulkResult{Line: line + 1, Error: localize(req, "unreadable line")})
+		}
+	}
+}
+
+// importLine decodes, validates and creates the account on one line
+func importLine(req *http.Request, store AccountStore, line int, b []byte) bulkResult {
+	var a Account
+	if err := newJSONDecoder(bytes.NewReader(b)).Decode(&a); err != nil {
+		return bulkResult{Line: line, Status: http.StatusBadRequest, Error: localize(req, decodeErrorMessage(err))}
+	}
+	res := bulkResult{Line: line, AccountID: a.ID}
+	if err := validateAccount(a); err != nil {
+		e := apiErrorFor(err)
+		res.Status, res.Error, res.Fields = e.HTTPStatus, localize(req, e.Message), e.Fields
+		return res
+	}
+	if p, ok := PrincipalFromContext(req.Context()); !ok || !p.mayAccess(a.ID) {
+		res.Status, res.Error = http.StatusForbidden, localize(req, "ownership not matched")
+		return res
+	}
+	if err := store.Create(req.Context(), a); err != nil {
+		e := apiErrorFor(err)
+		if errors.Is(err, ErrAlreadyExists) {
+			e.Message = "account already exists"
+		}
+		res.Status, res.Error = e.HTTPStatus, localize(req, e.Message)
+		return res
+	}
+	res.OK = true
+	return res
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBulkImportHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		caller     *Principal
+		body       string
+		wantOK     []bool // per reported line
+		wantStatus []int  // per reported line, 0 for success
+		wantStored []string
+	}{
+		{
+			name:       "all valid",
+			body:       `{"account_id":"1","name":"a"}` + "\n" + `{"account_id":"2","name":"b"}` + "\n",
+			wantOK:     []bool{true, true},
+			wantStored: []string{"1", "2"},
+		},
+		{
+			name:       "malformed line does not abort",
+			body:       `{"account_id":"1","name":"a"}` + "\n" + `{"account_id":` + "\n" + `{"account_id":"3","name":"c"}`,
+			wantOK:     []bool{true, false, true},
+			wantStored: []string{"1", "3"},
+		},
+		{
+			name:       "invalid account reported",
+			body:       `{"account_id":"1"}` + "\n" + `{"account_id":"2","name":"b"}`,
+			wantOK:     []bool{false, true},
+			wantStored: []string{"2"},
+		},
+		{
+			name:       "blank lines skipped",
+			body:       "\n" + `{"account_id":"1","name":"a"}` + "\n\n",
+			wantOK:     []bool{true},
+			wantStored: []string{"1"},
+		},
+		{
+			name:   "line too long",
+			body:   `{"account_id":"1","name":"` + strings.Repeat("a", maxBulkLine) + `"}`,
+			wantOK: []bool{false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMapStore()
+			store.Put(context.Background(), Account{ID: "0", Name: "old"})
+			caller := tt.caller
+			if caller == nil {
+				caller = &Principal{ID: "ops", Roles: []string{adminRole}}
+			}
+			req := httptest.NewRequest(http.MethodPost, "/account/bulk", strings.NewReader(tt.body))
+			req = req.WithContext(WithPrincipal(req.Context(), caller))
+			rec := httptest.NewRecorder()
+			captureStdout(t, func() { BulkImportHandler(store)(rec, req) })
+
+			if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			var got []bool
+			sc := bufio.NewScanner(rec.Body)
+			for sc.Scan() {
+				var res bulkResult
+				if err := json.Unmarshal(sc.Bytes(), &res); err != nil {
+					t.Fatalf("result line %q: %v", sc.Text(), err)
+				}
+				if !res.OK && res.Error == "" {
+					t.Errorf("line %d failed without an error", res.Line)
+				}
+				if tt.wantStatus != nil && len(got) < len(tt.wantStatus) && res.Status != tt.wantStatus[len(got)] {
+					t.Errorf("line %d status = %d, want %d", res.Line, res.Status, tt.wantStatus[len(got)])
+				}
+				got = append(got, res.OK)
+			}
+			if len(got) != len(tt.wantOK) {
+				t.Fatalf("results = %v, want %v", got, tt.wantOK)
+			}
+			for i := range got {
+				if got[i] != tt.wantOK[i] {
+					t.Errorf("result %d ok = %v, want %v", i, got[i], tt.wantOK[i])
+				}
+			}
+			for _, id := range tt.wantStored {
+				if _, err := store.Get(context.Background(), id); err != nil {
+					t.Errorf("account %s not stored: %v", id, err)
+				}
+			}
+			if a, _ := store.Get(context.Background(), "0"); a.Name != "old" {
+				t.Errorf("existing account overwritten: %+v", a)
+			}
+		})
+	}
+}
+
+func TestBulkImportHandlerCancelled(t *testing.T) {
+	store := NewMapStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	body := `{"account_id":"1","name":"a"}` + "\n" + `{"account_id":"2","name":"b"}`
+	req := httptest.NewRequest(http.MethodPost, "/account/bulk", strings.NewReader(body)).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	BulkImportHandler(store)(rec, req)
+
+	if rec.Body.Len() != 0 {
+		t.Errorf("cancelled import reported %q", rec.Body.String())
+	}
+	if _, err := store.Get(context.Background(), "1"); err == nil {
+		t.Error("cancelled import stored an account")
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type cacheEntry struct {
+	base   string
+	key    string
+	path   string
+	resp   StoredResponse
+	stored time.Time
+}
+
+// cacheGroup holds the variants cached for one base key, told apart by
+// the values of the request headers named in vary
+type cacheGroup struct {
+	vary     []string
+	variants map[string]*list.Element
+}
+
+// responseCache holds the responses CacheMiddleware serves. Entries are
+// kept in insertion order so the oldest is evicted first once full.
+type responseCache struct {
+	ttl   time.Duration
+	max   int
+	clock Clock
+
+	mu     sync.Mutex
+	order  *list.List // of *cacheEntry, oldest first
+	groups map[string]*cacheGroup
+	writes uint64 // bumped by every write, to drop fills that raced one
+}
+
+// CacheMiddleware serves repeated GETs from memory for up to ttl, holding
+// at most maxEntries responses. Only 200s without Set-Cookie or a
+// Cache-Control forbidding it are kept; hits carry an Age header. Entries
+// are keyed by tenant, principal, method, URL and the request headers named
+// in the response's Vary. The tenant is the one the tenant middleware
+// resolved, or else the raw X-Tenant-ID, so the cache may sit on either
+// side of it. Any other method on a path drops that path's entries, so a
+// PUT to /account/1 is seen by the next GET. Conditional requests always
+// go through so ETag checks still apply. Bodies are replayed verbatim,
+// request_id included. Register it after authentication: a hit is served
+// without reaching anything behind it. clock ages the entries; nil means
+// the real clock.
+func CacheMiddleware(ttl time.Duration, maxEntries int, clock Clock) mux.MiddlewareFunc {
+	c := &responseCache{
+		ttl:    ttl,
+		max:    maxEntries,
+		clock:  clockOrReal(clock),
+		order:  list.New(),
+		groups: map[string]*cacheGroup{},
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			path := req.URL.EscapedPath()
+			if req.Method != http.MethodGet && req.Method != http.MethodHead {
+				next.ServeHTTP(w, req)
+				c.invalidate(path)
+				return
+			}
+			if req.Method != http.MethodGet || req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			base := cacheBaseKey(req)
+			if e, age, ok := c.get(base, req); ok {
+				w.Header().Set("Age", strconv.Itoa(int(age/time.Second)))
+				writeStoredResponse(w, &e.resp)
+				return
+			}
+
+			gen := c.generation()
+			rw := wrapResponseWriter(w)
+			var body bytes.Buffer
+			rw.tee = &body
+			next.ServeHTTP(rw, req)
+			if cacheable(rw.Status(), w.Header()) {
+				c.put(base, path, gen, req, StoredResponse{Status: rw.Status(), Header: w.Header().Clone(), Body: body.Bytes()})
+			}
+		})
+	}
+}
+
+// cacheBaseKey identifies req up to its Vary headers
+func cacheBaseKey(req *http.Request) string {
+	tenant := req.Header.Get("X-Tenant-ID")
+	if t, ok := TenantFromContext(req.Context()); ok {
+		tenant = t.ID
+	}
+	var who string
+	if p, ok := PrincipalFromContext(req.Context()); ok {
+		who = p.ID
+	}
+	return tenant + "\n" + who + "\n" + req.Method + " " + req.URL.RequestURI()
+}
+
+// cacheable reports whether a response may be stored
+func cacheable(status int, h http.Header) bool {
+	if status != http.StatusOK || h.Get("Set-Cookie") != "" || h.Get("Vary") == "*" {
+		return false
+	}
+	cc := strings.ToLower(h.Get("Cache-Control"))
+	return !strings.Contains(cc, "no-store") && !strings.Contains(cc, "private")
+}
+
+// varyKey extends base with req's values of the headers in vary
+func varyKey(base string, vary []string, req *http.Request) string {
+	var b strings.Builder
+	b.WriteString(base)
+	for _, name := range vary {
+		b.WriteString("\n" + name + ": " + strings.Join(req.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+func (c *responseCache) get(base string, req *http.Request) (*cacheEntry, time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g, ok := c.groups[base]
+	if !ok {
+		return nil, 0, false
+	}
+	el, ok := g.variants[varyKey(base, g.vary, req)]
+	if !ok {
+		return nil, 0, false
+	}
+	e := el.Value.(*cacheEntry)
+	age := c.clock.Now().Sub(e.stored)
+	if age >= c.ttl {
+		c.remove(el)
+		return nil, 0, false
+	}
+	return e, age, true
+}
+
+func (c *responseCache) put(base, path string, gen uint64, req *http.Request, resp StoredResponse) {
+	var vary []string
+	for _, v := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				vary = append(vary, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	key := varyKey(base, vary, req)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.max <= 0 || c.writes != gen {
+		// something was written while this response was being built
+		return
+	}
+	if g, ok := c.groups[base]; ok {
+		if el, ok := g.variants[key]; ok {
+			c.remove(el)
+		}
+	}
+	for c.order.Len() >= c.max {
+		c.remove(c.order.Front())
+	}
+	g, ok := c.groups[base]
+	if !ok {
+		g = &cacheGroup{variants: map[string]*list.Element{}}
+		c.groups[base] = g
+	}
+	g.vary = vary
+	g.variants[key] = c.order.PushBack(&cacheEntry{base: base, key: key, path: path, resp: resp, stored: c.clock.Now()})
+}
+
+// generation returns the count of writes seen so far
+func (c *responseCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writes
+}
+
+// invalidate drops every entry for path
+func (c *responseCache) invalidate(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes++
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*cacheEntry).path == path {
+			c.remove(el)
+		}
+		el = next
+	}
+}
+
+func (c *responseCache) remove(el *list.Element) {
+	e := el.Value.(*cacheEntry)
+	if g, ok := c.groups[e.base]; ok {
+		delete(g.variants, e.key)
+		if len(g.variants) == 0 {
+			delete(c.groups, e.base)
+		}
+	}
+	c.order.Remove(el)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type cachedRequest struct {
+	method, path, tenant, auth, lang string
+}
+
+func TestCacheMiddleware(t *testing.T) {
+	get := cachedRequest{method: http.MethodGet, path: "/account/1", tenant: "acme", auth: "1"}
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		cookie    bool
+		between   func(*fakeClock)
+		requests  []cachedRequest
+		wantCalls int
+		wantAge   bool // whether the last response came from the cache
+	}{
+		{name: "hit", ttl: time.Minute, requests: []cachedRequest{get, get}, wantCalls: 1, wantAge: true},
+		{name: "expired", ttl: time.Minute, between: func(c *fakeClock) { c.Advance(2 * time.Minute) }, requests: []cachedRequest{get, get}, wantCalls: 2},
+		{name: "put invalidates", ttl: time.Minute, requests: []cachedRequest{get, {method: http.MethodPut, path: "/account/1", tenant: "acme", auth: "1"}, get}, wantCalls: 3},
+		{name: "other path kept", ttl: time.Minute, requests: []cachedRequest{get, {method: http.MethodPut, path: "/account/2", tenant: "acme", auth: "1"}, get}, wantCalls: 2, wantAge: true},
+		{name: "other tenant", ttl: time.Minute, requests: []cachedRequest{get, {method: http.MethodGet, path: "/account/1", tenant: "globex", auth: "1"}}, wantCalls: 2},
+		{name: "no tenant", ttl: time.Minute, requests: []cachedRequest{get, {method: http.MethodGet, path: "/account/1", auth: "1"}}, wantCalls: 2},
+		{name: "other principal", ttl: time.Minute, requests: []cachedRequest{get, {method: http.MethodGet, path: "/account/1", tenant: "acme", auth: "2"}}, wantCalls: 2},
+		{name: "vary", ttl: time.Minute, requests: []cachedRequest{get, {method: http.MethodGet, path: "/account/1", tenant: "acme", auth: "1", lang: "fr"}}, wantCalls: 2},
+		{name: "set-cookie not cached", ttl: time.Minute, cookie: true, requests: []cachedRequest{get, get}, wantCalls: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			clock := newFakeClock(time.Unix(1700000000, 0))
+			h := AuthenticateMiddleware(AuthConfig{})(CacheMiddleware(tt.ttl, 10, clock)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				calls++
+				w.Header().Set("Vary", "Accept-Language")
+				if tt.cookie {
+					http.SetCookie(w, &http.Cookie{Name: "session", Value: "x"})
+				}
+				respondJSON(w, http.StatusOK, map[string]string{"call": strconv.Itoa(calls)})
+			})))
+
+			var rec *httptest.ResponseRecorder
+			for i, r := range tt.requests {
+				if i > 0 && tt.between != nil {
+					tt.between(clock)
+				}
+				req := httptest.NewRequest(r.method, r.path, nil)
+				req.Header.Set("Authorization", r.auth)
+				if r.tenant != "" {
+					req.Header.Set("X-Tenant-ID", r.tenant)
+				}
+				if r.lang != "" {
+					req.Header.Set("Accept-Language", r.lang)
+				}
+				rec = httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("handler calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if hasAge := rec.Header().Get("Age") != ""; hasAge != tt.wantAge {
+				t.Errorf("Age header present = %v, want %v", hasAge, tt.wantAge)
+			}
+		})
+	}
+}
+
+func TestCacheMiddlewareResolvedTenant(t *testing.T) {
+	calls := 0
+	h := CacheMiddleware(time.Minute, 10, nil)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		respondJSON(w, http.StatusOK, nil)
+	}))
+	for _, tenant := range []string{"acme", "globex", "acme"} {
+		req := httptest.NewRequest(http.MethodGet, "/accounts", nil)
+		req = req.WithContext(WithTenant(req.Context(), Tenant{ID: tenant}))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if calls != 2 {
+		t.Errorf("handler calls = %d, want 2", calls)
+	}
+}
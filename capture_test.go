@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyCaptureMiddlewareRedactsHeaders(t *testing.T) {
+	tests := []struct {
+		header, value string
+	}{
+		{"Authorization", "Bearer SECRETBEARER"},
+		{"Cookie", "access_token=SECRETTOKEN"},
+		{"X-Api-Key", "SECRETKEY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			h := BodyCaptureMiddleware(BodyCaptureConfig{Enabled: true, MaxBytes: 1024})(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+			req := httptest.NewRequest(http.MethodGet, "/account/1", nil)
+			req.Header.Set(tt.header, tt.value)
+			req.Header.Set("X-Trace", "visible")
+			out := captureStdout(t, func() { h.ServeHTTP(httptest.NewRecorder(), req) })
+			if strings.Contains(out, tt.value) {
+				t.Errorf("log leaks %s: %s", tt.header, out)
+			}
+			if !strings.Contains(out, "visible") {
+				t.Errorf("log lost unredacted header: %s", out)
+			}
+		})
+	}
+}
+
+func TestBodyCaptureMiddlewareRedactsBodyFields(t *testing.T) {
+	tests := []struct {
+		name, body, leak string
+	}{
+		{"top level", `{"name":"a","password":"hunter2"}`, "hunter2"},
+		{"nested", `{"user":{"Token":"abc123"}}`, "abc123"},
+		{"truncated", `{"name":"a","secret":"` + strings.Repeat("x", 64) + `"}`, "xxxx"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := BodyCaptureMiddleware(BodyCaptureConfig{Enabled: true, MaxBytes: 40})(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				io.Copy(io.Discard, req.Body)
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/accounts", strings.NewReader(tt.body))
+			out := captureStdout(t, func() { h.ServeHTTP(httptest.NewRecorder(), req) })
+			if strings.Contains(out, tt.leak) {
+				t.Errorf("log leaks %q: %s", tt.leak, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/gorilla/mux"
+)
+
+// Capability names request state a middleware establishes for, or expects
+// from, the middleware in front of it
+type Capability string
+
+const (
+	// The caller's identity has been verified
+	CapAuthenticated Capability = "authenticated"
+)
+
+// DeclaredMiddleware is a middleware together with the capabilities it needs
+// from earlier middleware and the ones it adds for later middleware
+type DeclaredMiddleware struct {
+	Name     string
+	Requires []Capability
+	Provides []Capability
+	Func     mux.MiddlewareFunc
+}
+
+// ValidateChain checks that every middleware's requirements are provided by
+// one registered before it (i.e. further out). chain is in r.Use order.
+func ValidateChain(chain ...DeclaredMiddleware) error {
+	have := map[Capability]bool{}
+	for _, m := range chain {
+		for _, c := range m.Requires {
+			if !have[c] {
+				return fmt.Errorf("middleware %q requires %q, which no earlier middleware provides", m.Name, c)
+			}
+		}
+		for _, c := range m.Provides {
+			have[c] = true
+		}
+	}
+	return nil
+}
+
+// UseChain validates chain and, if it is well ordered, registers it on r
+func UseChain(r *mux.Router, chain ...DeclaredMiddleware) error {
+	if err := ValidateChain(chain...); err != nil {
+		return err
+	}
+	names := make(chainMarker, len(chain))
+	for i, m := range chain {
+		r.Use(m.Func)
+		names[i] = m.Name
+	}
+	// mux keeps its middleware private, so the names are left on r for
+	// ListRoutes in a route that never matches
+	r.NewRoute().BuildOnly().Handler(names)
+	return nil
+}
+
+// chainMarker names, outermost first, the middleware one UseChain call
+// registered on the router holding it
+type chainMarker []string
+
+func (chainMarker) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	http.NotFound(w, req)
+}
+
+// With wraps a single route's handler in mws, the first being outermost as
+// with r.Use. Use it, or a subrouter's Use, to protect some routes without
+// putting public ones such as /healthz behind the same middleware.
+func With(h http.Handler, mws ...mux.MiddlewareFunc) http.Handler {
+	names := make([]string, len(mws))
+	for i, mw := range mws {
+		names[i] = funcName(reflect.ValueOf(mw))
+	}
+	inner := h
+	if w, ok := h.(*withHandler); ok {
+		inner = w.inner
+		names = append(names, w.middleware...)
+	}
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return &withHandler{Handler: h, inner: inner, middleware: names}
+}
+
+// withHandler is a handler wrapped by With, remembering what it wrapped so
+// ListRoutes can tell the handler from its middleware
+type withHandler struct {
+	http.Handler
+	inner      http.Handler
+	middleware []string
+}
+
+// ToMux adapts a Chain-style Middleware for r.Use
+func ToMux(m Middleware) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return m(next.ServeHTTP)
+	}
+}
+
+// FromMux adapts a mux.MiddlewareFunc for Chain
+func FromMux(m mux.MiddlewareFunc) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return m(next).ServeHTTP
+	}
+}
+
+// DeclareAuthenticate wraps AuthenticateMiddleware with its declared capabilities
+func DeclareAuthenticate(cfg AuthConfig) DeclaredMiddleware {
+	return DeclaredMiddleware{
+		Name:     "Authenticate",
+		Provides: []Capability{CapAuthenticated},
+		Func:     AuthenticateMiddleware(cfg),
+	}
+}
+
+// DeclareRequireRole wraps RequireRole with its declared capabilities. It
+// reads the Principal, so it needs authentication in front of it.
+func DeclareRequireRole(role string) DeclaredMiddleware {
+	return DeclaredMiddleware{
+		Name:     "RequireRole(" + role + ")",
+		Requires: []Capability{CapAuthenticated},
+		Func:     RequireRole(role),
+	}
+}
+
+// DeclareAccountAuth wraps AccountAuthMiddleware with its declared capabilities
+func DeclareAccountAuth(cfg AuthConfig) DeclaredMiddleware {
+	return DeclaredMiddleware{
+		Name:     "AccountAuth",
+		Provides: []Capability{CapAuthenticated},
+		Func:     AccountAuthMiddleware(cfg),
+	}
+}
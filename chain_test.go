@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestValidateChain(t *testing.T) {
+	auth := AuthConfig{}
+	tests := []struct {
+		name    string
+		chain   []DeclaredMiddleware
+		wantErr string
+	}{
+		{"empty", nil, ""},
+		{"authenticate then role", []DeclaredMiddleware{DeclareAuthenticate(auth), DeclareRequireRole("admin")}, ""},
+		{"account auth then role", []DeclaredMiddleware{DeclareAccountAuth(auth), DeclareRequireRole("admin")}, ""},
+		{"role before authenticate", []DeclaredMiddleware{DeclareRequireRole("admin"), DeclareAuthenticate(auth)}, `"RequireRole(admin)" requires "authenticated"`},
+		{"role alone", []DeclaredMiddleware{DeclareRequireRole("admin")}, `"RequireRole(admin)" requires "authenticated"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateChain(tt.chain...)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("ValidateChain: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("ValidateChain = %v, want an error containing %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegisterAdminRoutes(t *testing.T) {
+	issuer := &TokenIssuer{Secret: []byte("secret"), TTL: time.Hour}
+	token := func(roles ...string) string {
+		tok, err := issuer.Issue("1", roles...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + tok
+	}
+	srv, _ := NewTestServer(t, WithAuth(AuthConfig{Tokens: issuer}), WithMaintenance(&AtomicBool{}))
+
+	tests := []struct {
+		name   string
+		path   string
+		auth   string
+		method string
+		want   int
+	}{
+		{"anonymous", "/admin/maintenance", "", http.MethodGet, http.StatusUnauthorized},
+		{"without the role", "/admin/maintenance", token(), http.MethodGet, http.StatusForbidden},
+		{"admin", "/admin/maintenance", token("admin"), http.MethodGet, http.StatusOK},
+		{"admin route table", "/admin/routes", token("admin"), http.MethodGet, http.StatusOK},
+		{"route table anonymous", "/admin/routes", "", http.MethodGet, http.StatusUnauthorized},
+		{"route table without the role", "/admin/routes", token(), http.MethodGet, http.StatusForbidden},
+		{"wrong method", "/admin/maintenance", token("admin"), http.MethodPut, http.StatusMethodNotAllowed},
+		{"not mounted", "/admin/ratelimits", token("admin"), http.MethodGet, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithPerRoute(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		auth string
+		want int
+	}{
+		{"health without auth", "/healthz", "", http.StatusOK},
+		{"health with any auth", "/healthz", "999", http.StatusOK},
+		{"account without auth", "/account/123", "", http.StatusUnauthorized},
+		{"account of someone else", "/account/123", "999", http.StatusUnauthorized},
+		{"own account", "/account/123", "123", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			captureStdout(t, func() { buildPerRouteHandler().ServeHTTP(rec, req) })
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareAdapters(t *testing.T) {
+	tests := []struct {
+		name    string
+		mw      Middleware
+		path    string
+		wantLog bool
+	}{
+		{"logging", LoggingFunc(), "/account/1", true},
+		{"logging failure", LoggingFunc(), "/account/fail", true},
+		{"mux middleware round trip", FromMux(MaxURILengthMiddleware(12)), "/account/1", false},
+		{"mux middleware rejecting", FromMux(MaxURILengthMiddleware(12)), "/account/too-long", false},
+	}
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "/fail") {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte("ok"))
+	}
+	duration := regexp.MustCompile(`duration=\S+`)
+	serve := func(t *testing.T, h http.Handler, path string) (*httptest.ResponseRecorder, string) {
+		rec := httptest.NewRecorder()
+		out := captureStdout(t, func() { h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil)) })
+		return rec, duration.ReplaceAllString(out, "duration=X")
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chained, chainedLog := serve(t, Chain(handler, tt.mw), tt.path)
+
+			r := mux.NewRouter()
+			r.PathPrefix("/").HandlerFunc(handler)
+			r.Use(ToMux(tt.mw))
+			used, usedLog := serve(t, r, tt.path)
+
+			if chained.Code != used.Code || chained.Body.String() != used.Body.String() {
+				t.Errorf("Chain gave %d %q, r.Use gave %d %q", chained.Code, chained.Body, used.Code, used.Body)
+			}
+			if (chainedLog != "") != tt.wantLog {
+				t.Errorf("logged %q, want a log line = %v", chainedLog, tt.wantLog)
+			}
+			if chainedLog != usedLog {
+				t.Errorf("Chain logged %q, r.Use logged %q", chainedLog, usedLog)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+// Package client is a small Go client for the account service.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Account as returned by GET /account/{id}
+type Account struct {
+	ID      string `json:"account_id"`
+	Name    string `json:"name"`
+	Balance string `json:"balance"` // decimal, e.g. "12.34"; parse it exactly, not as a float
+}
+
+// envelope is the wrapper around every successful response body
+type envelope struct {
+	Data      interface{} `json:"data"`
+	RequestID string      `json:"request_id"`
+}
+
+// APIError is a non-2xx response from the service
+type APIError struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"error"`
+	Message    string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("account service: %d %s", e.StatusCode, e.Message)
+}
+
+// Client calls the account service, retrying on 429 and 503
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client // defaults to http.DefaultClient
+
+	// MaxRetries is how many times a throttled request is retried
+	MaxRetries int
+	// BaseBackoff is the first retry delay; it doubles on each attempt.
+	// A Retry-After header from the server takes precedence.
+	BaseBackoff time.Duration
+}
+
+// New returns a Client for the service at baseURL with default retry settings
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:     baseURL,
+		MaxRetries:  3,
+		BaseBackoff: 100 * time.Millisecond,
+	}
+}
+
+// GetAccount fetches account id, authenticating with token
+func (c *Client) GetAccount(ctx context.Context, id, token string) (*Account, error) {
+	var a Account
+	if err := c.get(ctx, "/account/"+url.PathEscape(id), token, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (c *Client) get(ctx context.Context, path, token string, out interface{}) error {
+	backoff := c.BaseBackoff
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+path, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", token)
+
+		resp, err := c.httpClient().Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode/100 == 2 {
+			err := json.NewDecoder(resp.Body).Decode(&envelope{Data: out})
+			resp.Body.Close()
+			return err
+		}
+
+		apiErr := decodeError(resp)
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+		if !retryable || attempt >= c.MaxRetries {
+			return apiErr
+		}
+
+		wait := backoff
+		if d, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+			wait = d
+		}
+		backoff *= 2
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// decodeError reads the JSON error body of resp and closes it
+func decodeError(resp *http.Response) *APIError {
+	defer resp.Body.Close()
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if json.Unmarshal(body, apiErr) != nil || apiErr.Message == "" {
+		apiErr.Message = apiErr.Code
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// reply is one canned response from the test server
+type reply struct {
+	status     int
+	retryAfter string
+	body       string
+}
+
+func TestGetAccount(t *testing.T) {
+	ok := reply{http.StatusOK, "", `{"data":{"account_id":"1","name":"a","balance":"1.50"},"request_id":"r"}`}
+	unavailable := reply{http.StatusServiceUnavailable, "0", `{"code":503,"error":"unavailable","message":"account store unavailable"}`}
+	tests := []struct {
+		name      string
+		replies   []reply
+		wantCalls int
+		wantErr   *APIError
+	}{
+		{"success", []reply{ok}, 1, nil},
+		{"transient 503 then success", []reply{unavailable, ok}, 2, nil},
+		{"429 then success", []reply{{http.StatusTooManyRequests, "", `{"error":"rate limited"}`}, ok}, 2, nil},
+		{"retries exhausted", []reply{unavailable, unavailable, unavailable}, 3,
+			&APIError{StatusCode: http.StatusServiceUnavailable, Code: "unavailable", Message: "account store unavailable"}},
+		{"not retried", []reply{{http.StatusNotFound, "", `{"code":404,"error":"not_found","message":"account not found"}`}, ok}, 1,
+			&APIError{StatusCode: http.StatusNotFound, Code: "not_found", Message: "account not found"}},
+		{"no error body", []reply{{http.StatusForbidden, "", ""}}, 1,
+			&APIError{StatusCode: http.StatusForbidden, Message: "Forbidden"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if got := req.Header.Get("Authorization"); got != "tok" {
+					t.Errorf("Authorization = %q, want tok", got)
+				}
+				r := tt.replies[calls]
+				calls++
+				if r.retryAfter != "" {
+					w.Header().Set("Retry-After", r.retryAfter)
+				}
+				w.WriteHeader(r.status)
+				w.Write([]byte(r.body))
+			}))
+			defer srv.Close()
+
+			c := New(srv.URL)
+			c.MaxRetries = 2
+			c.BaseBackoff = time.Millisecond
+			a, err := c.GetAccount(context.Background(), "1", "tok")
+
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("GetAccount: %v", err)
+				}
+				if *a != (Account{ID: "1", Name: "a", Balance: "1.50"}) {
+					t.Errorf("account = %+v", a)
+				}
+				return
+			}
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || *apiErr != *tt.wantErr {
+				t.Errorf("err = %#v, want %#v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"", 0, false},
+		{"3", 3 * time.Second, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{"Mon, 02 Jan 2006 15:04:05 GMT", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, ok := retryAfter(tt.in)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("retryAfter(%q) = %v, %v; want %v, %v", tt.in, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
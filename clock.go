@@ -0,0 +1,22 @@
+package main
+
+import "time"
+
+// Clock tells the time. Time-dependent code takes one instead of calling
+// time.Now so tests can control the time it sees.
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the Clock used when none is configured
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// clockOrReal returns c, or the real clock when c is nil
+func clockOrReal(c Clock) Clock {
+	if c == nil {
+		return realClock{}
+	}
+	return c
+}
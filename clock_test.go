@@ -0,0 +1,98 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock for tests that only moves when Advance is called
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func newFakeClock(t time.Time) *fakeClock {
+	return &fakeClock{t: t}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+// Advance moves the clock forward by d
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func TestInjectedClock(t *testing.T) {
+	tests := []struct {
+		name string
+		// check reports whether a time-dependent operation succeeds when
+		// performed elapsed after setup, both on clock
+		check   func(clock *fakeClock) func() bool
+		elapsed time.Duration
+		want    bool
+	}{
+		{"token within TTL", tokenValid, 59 * time.Second, true},
+		{"token past TTL", tokenValid, 61 * time.Second, false},
+		{"rate limit not refilled", rateLimitRefilled, 30 * time.Second, false},
+		{"rate limit refilled", rateLimitRefilled, time.Minute, true},
+		{"refresh token within TTL", refreshValid, 59 * time.Second, true},
+		{"refresh token past TTL", refreshValid, 61 * time.Second, false},
+		{"revocation within token TTL", revocationHeld, 59 * time.Second, true},
+		{"revocation past token TTL", revocationHeld, 61 * time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock(time.Unix(1700000000, 0))
+			check := tt.check(clock)
+			clock.Advance(tt.elapsed)
+			if got := check(); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func tokenValid(clock *fakeClock) func() bool {
+	ti := &TokenIssuer{Secret: []byte("k"), TTL: time.Minute, Clock: clock}
+	tok, _ := ti.Issue("1")
+	return func() bool {
+		_, err := ti.Parse(tok)
+		return err == nil
+	}
+}
+
+func rateLimitRefilled(clock *fakeClock) func() bool {
+	l := NewRateLimiter(RateLimitConfig{Clock: clock})
+	limit := RateLimit{Requests: 1, Window: time.Minute}
+	l.take("k", limit)
+	return func() bool {
+		_, ok := l.take("k", limit)
+		return ok
+	}
+}
+
+func refreshValid(clock *fakeClock) func() bool {
+	s := NewRefreshTokenStore(time.Minute)
+	s.Clock = clock
+	tok, _ := s.Issue("1")
+	return func() bool {
+		_, _, err := s.Rotate(tok)
+		return err == nil
+	}
+}
+
+func revocationHeld(clock *fakeClock) func() bool {
+	ti := &TokenIssuer{Secret: []byte("k"), TTL: time.Minute, Clock: clock}
+	s := NewRevocationStore(ti)
+	s.Revoke("jti", ti.now().Add(ti.TTL))
+	return func() bool {
+		return s.IsRevoked("jti")
+	}
+}
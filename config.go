@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Shortest AUTH_SECRET accepted, in bytes: an HS256 key should be no
+// shorter than the hash
+const minAuthSecretLength = 32
+
+// ConfigFromEnv builds a ServerConfig from the environment, starting from
+// DefaultServerConfig for anything unset. Every malformed variable is
+// reported, not just the first.
+//
+//	ADDR                      listen address, e.g. ":8080"
+//	AUTH_SECRET               token signing key, at least 32 bytes
+//	LOG_LEVEL                 debug, info, warn or error
+//	READ_TIMEOUT              duration, e.g. "5s"
+//	HANDLER_TIMEOUT           duration
+//	SHUTDOWN_TIMEOUT          positive duration
+//	MAX_HEADER_BYTES          integer
+//	MAX_URI_LENGTH            integer
+//	MAX_CONCURRENT_REQUESTS   integer
+//	CONCURRENCY_QUEUE_TIMEOUT duration
+//	DISABLE_KEEP_ALIVES       boolean
+//	TRAILING_SLASH            off, redirect or strip
+//	ERROR_FORMAT              flat or jsonapi
+func ConfigFromEnv() (ServerConfig, error) {
+	cfg := DefaultServerConfig()
+	var errs multiError
+
+	if v, ok := os.LookupEnv("ADDR"); ok {
+		cfg.Addr = v
+	}
+	if v, ok := os.LookupEnv("AUTH_SECRET"); ok {
+		if len(v) < minAuthSecretLength {
+			errs = append(errs, fmt.Errorf("AUTH_SECRET: must be at least %d bytes, got %d", minAuthSecretLength, len(v)))
+		}
+		cfg.AuthSecret = []byte(v)
+	}
+	if v, ok := os.LookupEnv("LOG_LEVEL"); ok {
+		level := strings.ToLower(v)
+		if _, known := logLevels[level]; !known {
+			errs = append(errs, fmt.Errorf("LOG_LEVEL: unknown level %q", v))
+		}
+		cfg.LogLevel = level
+	}
+	envDuration(&errs, "READ_TIMEOUT", &cfg.ReadTimeout)
+	envDuration(&errs, "HANDLER_TIMEOUT", &cfg.HandlerTimeout)
+	envDuration(&errs, "SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout)
+	if cfg.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("SHUTDOWN_TIMEOUT: must be positive, got %s", cfg.ShutdownTimeout))
+	}
+	envInt(&errs, "MAX_HEADER_BYTES", &cfg.MaxHeaderBytes)
+	envInt(&errs, "MAX_URI_LENGTH", &cfg.MaxURILength)
+	envInt(&errs, "MAX_CONCURRENT_REQUESTS", &cfg.MaxConcurrentRequests)
+	envDuration(&errs, "CONCURRENCY_QUEUE_TIMEOUT", &cfg.ConcurrencyQueueTimeout)
+	if v, ok := os.LookupEnv("DISABLE_KEEP_ALIVES"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("DISABLE_KEEP_ALIVES: invalid boolean %q", v))
+		}
+		cfg.DisableKeepAlives = b
+	}
+	if v, ok := os.LookupEnv("ERROR_FORMAT"); ok {
+		switch strings.ToLower(v) {
+		case "flat":
+			cfg.ErrorFormat = ErrorFormatFlat
+		case "jsonapi":
+			cfg.ErrorFormat = ErrorFormatJSONAPI
+		default:
+			errs = append(errs, fmt.Errorf("ERROR_FORMAT: unknown format %q", v))
+		}
+	}
+	if v, ok := os.LookupEnv("TRAILING_SLASH"); ok {
+		switch strings.ToLower(v) {
+		case "off":
+			cfg.TrailingSlash = TrailingSlashOff
+		case "redirect":
+			cfg.TrailingSlash = TrailingSlashRedirect
+		case "strip":
+			cfg.TrailingSlash = TrailingSlashStrip
+		default:
+			errs = append(errs, fmt.Errorf("TRAILING_SLASH: unknown mode %q", v))
+		}
+	}
+	return cfg, errs.errOrNil()
+}
+
+func envDuration(errs *multiError, name string, dst *time.Duration) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		*errs = append(*errs, fmt.Errorf("%s: invalid duration %q", name, v))
+		return
+	}
+	*dst = d
+}
+
+func envInt(errs *multiError, name string, dst *int) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		*errs = append(*errs, fmt.Errorf("%s: invalid non-negative integer %q", name, v))
+		return
+	}
+	*dst = n
+}
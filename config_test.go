@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		check   func(ServerConfig) bool
+		wantErr []string
+	}{
+		{
+			name: "defaults",
+			check: func(c ServerConfig) bool {
+				return c.Addr == ":8000" && c.ShutdownTimeout == 10*time.Second && c.AuthSecret == nil && c.LogLevel == "info"
+			},
+		},
+		{
+			name: "overrides",
+			env: map[string]string{
+				"ADDR":             ":9000",
+				"AUTH_SECRET":      strings.Repeat("k", 32),
+				"LOG_LEVEL":        "WARN",
+				"READ_TIMEOUT":     "5s",
+				"SHUTDOWN_TIMEOUT": "30s",
+				"MAX_URI_LENGTH":   "1024",
+				"ERROR_FORMAT":     "jsonapi",
+				"TRAILING_SLASH":   "strip",
+			},
+			check: func(c ServerConfig) bool {
+				return c.Addr == ":9000" && c.ReadTimeout == 5*time.Second && c.ShutdownTimeout == 30*time.Second &&
+					c.MaxURILength == 1024 && c.ErrorFormat == ErrorFormatJSONAPI && c.TrailingSlash == TrailingSlashStrip &&
+					string(c.AuthSecret) == strings.Repeat("k", 32) && c.LogLevel == "warn"
+			},
+		},
+		{
+			name:    "malformed timeout",
+			env:     map[string]string{"READ_TIMEOUT": "soon"},
+			wantErr: []string{"READ_TIMEOUT"},
+		},
+		{
+			name:    "zero shutdown timeout",
+			env:     map[string]string{"SHUTDOWN_TIMEOUT": "0s"},
+			wantErr: []string{"SHUTDOWN_TIMEOUT"},
+		},
+		{
+			name:    "short auth secret",
+			env:     map[string]string{"AUTH_SECRET": "secret"},
+			wantErr: []string{"AUTH_SECRET"},
+		},
+		{
+			name:    "every error reported",
+			env:     map[string]string{"MAX_HEADER_BYTES": "-1", "ERROR_FORMAT": "xml", "LOG_LEVEL": "loud"},
+			wantErr: []string{"MAX_HEADER_BYTES", "ERROR_FORMAT", "LOG_LEVEL"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			cfg, err := ConfigFromEnv()
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("ConfigFromEnv: %v", err)
+				}
+				if !tt.check(cfg) {
+					t.Errorf("unexpected config %+v", cfg)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("ConfigFromEnv succeeded, want an error")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %s", err, want)
+				}
+			}
+		})
+	}
+}
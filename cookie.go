@@ -0,0 +1,18 @@
+package main
+
+import "net/http"
+
+// newSessionCookie builds a cookie for auth state scoped to the whole site.
+// It is HttpOnly and SameSite=Lax, and Secure only when req arrived over
+// TLS: browsers drop Secure cookies set over plain HTTP, which would break
+// local development, while production must never send them in the clear.
+func newSessionCookie(req *http.Request, name, value string) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Secure:   req.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
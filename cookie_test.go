@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-rest-api-example/testutil"
+)
+
+func TestNewSessionCookie(t *testing.T) {
+	tests := []struct {
+		name       string
+		tls        bool
+		wantSecure bool
+	}{
+		{"over TLS", true, true},
+		{"plain HTTP", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/auth/refresh", nil)
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			c := newSessionCookie(req, "access_token", "v")
+			if c.Secure != tt.wantSecure {
+				t.Errorf("Secure = %v, want %v", c.Secure, tt.wantSecure)
+			}
+			if !c.HttpOnly || c.SameSite != http.SameSiteLaxMode || c.Path != "/" {
+				t.Errorf("cookie = %+v, want HttpOnly, SameSite=Lax, Path=/", c)
+			}
+			if c.Name != "access_token" || c.Value != "v" {
+				t.Errorf("cookie = %s=%s", c.Name, c.Value)
+			}
+		})
+	}
+}
+
+func TestCSRFCookieAttributes(t *testing.T) {
+	tests := []struct {
+		name       string
+		tls        bool
+		wantSecure bool
+	}{
+		{"over TLS", true, true},
+		{"plain HTTP", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/account/1", nil)
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			rec, _ := testutil.InvokeMiddleware(CSRFMiddleware(), req)
+			cookies := rec.Result().Cookies()
+			if len(cookies) != 1 {
+				t.Fatalf("cookies = %v, want the CSRF cookie", cookies)
+			}
+			c := cookies[0]
+			if c.Secure != tt.wantSecure {
+				t.Errorf("Secure = %v, want %v", c.Secure, tt.wantSecure)
+			}
+			// scripts echo the token back, so unlike other session cookies it is readable
+			if c.HttpOnly || c.SameSite != http.SameSiteLaxMode {
+				t.Errorf("cookie = %+v, want readable with SameSite=Lax", c)
+			}
+		})
+	}
+}
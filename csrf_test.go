@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-rest-api-example/testutil"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		cookie     string
+		header     string
+		auth       string
+		wantStatus int
+		wantCalled bool
+		wantCookie bool
+	}{
+		{name: "GET is issued a cookie", method: http.MethodGet, wantStatus: http.StatusOK, wantCalled: true, wantCookie: true},
+		{name: "GET keeps its cookie", method: http.MethodGet, cookie: "abc", wantStatus: http.StatusOK, wantCalled: true},
+		{name: "POST with matching token", method: http.MethodPost, cookie: "abc", header: "abc", wantStatus: http.StatusOK, wantCalled: true},
+		{name: "POST without token", method: http.MethodPost, cookie: "abc", wantStatus: http.StatusForbidden},
+		{name: "POST with mismatched token", method: http.MethodPost, cookie: "abc", header: "xyz", wantStatus: http.StatusForbidden},
+		{name: "DELETE without cookie", method: http.MethodDelete, header: "abc", wantStatus: http.StatusForbidden},
+		{name: "POST with Authorization header", method: http.MethodPost, auth: "Bearer tok", wantStatus: http.StatusOK, wantCalled: true},
+		{name: "POST with Authorization header and stale cookie", method: http.MethodPost, cookie: "abc", auth: "123", wantStatus: http.StatusOK, wantCalled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/account/1", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: csrfCookie, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				req.Header.Set(csrfHeader, tt.header)
+			}
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec, next := testutil.InvokeMiddleware(CSRFMiddleware(), req)
+			testutil.AssertStatus(t, rec, tt.wantStatus)
+			if called := next != nil; called != tt.wantCalled {
+				t.Errorf("next called = %v, want %v", called, tt.wantCalled)
+			}
+			gotCookie := false
+			for _, c := range rec.Result().Cookies() {
+				gotCookie = gotCookie || c.Name == csrfCookie
+			}
+			if gotCookie != tt.wantCookie {
+				t.Errorf("cookie issued = %v, want %v", gotCookie, tt.wantCookie)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+// Context keys. Each value the package stores in a context.Context has its
+// own unexported key type here, so no other package can read or overwrite
+// it, and no two of ours can collide. Values are only ever set and read
+// through the accessors next to the feature that owns them:
+//
+//	principalKey      WithPrincipal, PrincipalFromContext
+//	requestIDKey      WithRequestID, RequestIDFromContext
+//	tenantKey         WithTenant, TenantFromContext
+//	routeTemplateKey  WithRouteTemplate, RouteTemplate
+//	localeKey         WithLocale, LocaleFromContext
+//	rawResponseKey    withRawResponse, rawResponse
+//	staleKey          withStaleFlag, markStale
+type (
+	principalKey     struct{}
+	requestIDKey     struct{}
+	tenantKey        struct{}
+	routeTemplateKey struct{}
+	localeKey        struct{}
+	rawResponseKey   struct{}
+	staleKey         struct{}
+)
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestContextAccessors(t *testing.T) {
+	principal := &Principal{ID: "1", Roles: []string{adminRole}}
+	stale := new(int32)
+	tests := []struct {
+		name     string
+		set      func(context.Context) context.Context
+		get      func(context.Context) (interface{}, bool)
+		want     interface{}
+		wantZero interface{} // what get returns when nothing was stored
+	}{
+		{"principal", func(ctx context.Context) context.Context { return WithPrincipal(ctx, principal) },
+			func(ctx context.Context) (interface{}, bool) { return PrincipalFromContext(ctx) }, principal, (*Principal)(nil)},
+		{"request id", func(ctx context.Context) context.Context { return WithRequestID(ctx, "req-1") },
+			func(ctx context.Context) (interface{}, bool) { return RequestIDFromContext(ctx) }, "req-1", ""},
+		{"tenant", func(ctx context.Context) context.Context { return WithTenant(ctx, Tenant{ID: "acme"}) },
+			func(ctx context.Context) (interface{}, bool) { return TenantFromContext(ctx) }, Tenant{ID: "acme"}, Tenant{}},
+		{"route template", func(ctx context.Context) context.Context { return WithRouteTemplate(ctx, "/account/{id}") },
+			func(ctx context.Context) (interface{}, bool) {
+				tpl := RouteTemplate(ctx)
+				return tpl, tpl != unmatchedRoute
+			}, "/account/{id}", unmatchedRoute},
+		{"locale", func(ctx context.Context) context.Context { return WithLocale(ctx, language.French) },
+			func(ctx context.Context) (interface{}, bool) {
+				tag := LocaleFromContext(ctx)
+				return tag, ctx.Value(localeKey{}) != nil
+			}, language.French, supportedLocales[0]},
+		{"raw response", withRawResponse,
+			func(ctx context.Context) (interface{}, bool) { raw := rawResponse(ctx); return raw, raw }, true, false},
+		{"stale flag", func(ctx context.Context) context.Context { return withStaleFlag(ctx, stale) },
+			func(ctx context.Context) (interface{}, bool) { f, ok := ctx.Value(staleKey{}).(*int32); return f, ok }, stale, (*int32)(nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.get(tt.set(context.Background()))
+			if !ok || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("round trip = %v, %v; want %v, true", got, ok, tt.want)
+			}
+
+			got, ok = tt.get(context.Background())
+			if ok || !reflect.DeepEqual(got, tt.wantZero) {
+				t.Errorf("unset = %v, %v; want %v, false", got, ok, tt.wantZero)
+			}
+
+			// a plain string key of the same name must not be mistaken for ours
+			got, ok = tt.get(context.WithValue(context.Background(), tt.name, tt.want))
+			if ok || !reflect.DeepEqual(got, tt.wantZero) {
+				t.Errorf("string key = %v, %v; want %v, false", got, ok, tt.wantZero)
+			}
+		})
+	}
+}
+
+func TestContextKeysDoNotCollide(t *testing.T) {
+	ctx := context.Background()
+	ctx = WithRequestID(ctx, "req-1")
+	ctx = WithRouteTemplate(ctx, "/account/{id}")
+	ctx = WithTenant(ctx, Tenant{ID: "acme"})
+	ctx = WithPrincipal(ctx, &Principal{ID: "7"})
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"request id", func() string { id, _ := RequestIDFromContext(ctx); return id }(), "req-1"},
+		{"route template", RouteTemplate(ctx), "/account/{id}"},
+		{"tenant", func() string { tn, _ := TenantFromContext(ctx); return tn.ID }(), "acme"},
+		{"principal", func() string { p, _ := PrincipalFromContext(ctx); return p.ID }(), "7"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
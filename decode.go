@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DisallowUnknownFields makes request decoding reject JSON object fields
+// the target type doesn't have. It is meant to be set once at startup.
+var DisallowUnknownFields = false
+
+// decodeJSON decodes the request body into v. On failure it returns a 400
+// APIError whose message says what was wrong in terms a client can act on,
+// or ErrBodyReadTimeout when the body arrived too slowly.
+func decodeJSON(req *http.Request, v interface{}) error {
+	if err := newJSONDecoder(req.Body).Decode(v); err != nil {
+		if errors.Is(err, ErrBodyReadTimeout) {
+			return err
+		}
+		return &APIError{HTTPStatus: http.StatusBadRequest, ErrorCode: "bad_request", Message: decodeErrorMessage(err), Err: err}
+	}
+	return nil
+}
+
+// newJSONDecoder returns a decoder for r honouring DisallowUnknownFields
+func newJSONDecoder(r io.Reader) *json.Decoder {
+	dec := json.NewDecoder(r)
+	if DisallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	return dec
+}
+
+// decodeErrorMessage explains a json.Decoder error
+func decodeErrorMessage(err error) string {
+	var syntax *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return "empty body"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "malformed JSON: body ends early"
+	case errors.As(err, &syntax):
+		return fmt.Sprintf("malformed JSON at offset %d", syntax.Offset)
+	case errors.As(err, &typ):
+		if typ.Field != "" {
+			return fmt.Sprintf("field %s must be %s", typ.Field, typ.Type)
+		}
+		return fmt.Sprintf("body must be %s", typ.Type)
+	case errors.Is(err, ErrInvalidMoney):
+		return "invalid money amount"
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no typed error for this case
+		return "unknown field " + strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+	}
+	return "malformed JSON"
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		disallowExtra bool
+		wantMsg       string // empty when decoding succeeds
+	}{
+		{"valid", `{"account_id":"1","name":"a"}`, false, ""},
+		{"empty body", ``, false, "empty body"},
+		{"syntax error", `{"name": a}`, false, "malformed JSON at offset 10"},
+		{"truncated", `{"name":"a"`, false, "malformed JSON: body ends early"},
+		{"wrong field type", `{"name":1}`, false, "field name must be string"},
+		{"wrong body type", `[1]`, false, "body must be main.Account"},
+		{"bad money", `{"balance":"1.234"}`, false, "invalid money amount"},
+		{"unknown field allowed", `{"nickname":"a"}`, false, ""},
+		{"unknown field rejected", `{"nickname":"a"}`, true, "unknown field nickname"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(v bool) { DisallowUnknownFields = v }(DisallowUnknownFields)
+			DisallowUnknownFields = tt.disallowExtra
+
+			var a Account
+			err := decodeJSON(httptest.NewRequest(http.MethodPut, "/", strings.NewReader(tt.body)), &a)
+			if tt.wantMsg == "" {
+				if err != nil {
+					t.Fatalf("decodeJSON = %v", err)
+				}
+				return
+			}
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("decodeJSON = %v, want an APIError", err)
+			}
+			if apiErr.HTTPStatus != http.StatusBadRequest || apiErr.Message != tt.wantMsg {
+				t.Errorf("got %d %q, want 400 %q", apiErr.HTTPStatus, apiErr.Message, tt.wantMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	ErrUnauthorized       = errors.New("unauthorized")
+	ErrForbidden          = errors.New("forbidden")
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+// APIError is an error response: the HTTP status, a stable machine-readable
+// code and a message for humans. It wraps the error that caused it. The
+// flat body keeps its original keys, so HTTPStatus goes out as "code" and
+// ErrorCode as "error".
+type APIError struct {
+	HTTPStatus int    `json:"code"`
+	ErrorCode  string `json:"error"`
+	Message    string `json:"message"`
+	// Fields holds per-field reasons when validation failed
+	Fields map[string]string `json:"fields,omitempty"`
+	Err    error             `json:"-"`
+}
+
+func (e *APIError) Error() string { return e.Message }
+func (e *APIError) Unwrap() error { return e.Err }
+
+// ErrorMapFunc turns an error into the status and APIError to answer with
+type ErrorMapFunc func(error) (int, APIError)
+
+type errorRule struct {
+	matches func(error) bool
+	mapTo   ErrorMapFunc
+}
+
+// ErrorMapper maps domain errors onto HTTP responses from one place, so
+// handlers needn't switch on errors themselves. An *APIError anywhere in
+// the chain is used as-is; otherwise rules are tried in the order
+// registered, and an error none matches is a 500 whose message reveals
+// nothing about the cause. Register rules at startup, before serving.
+type ErrorMapper struct {
+	rules []errorRule
+}
+
+func NewErrorMapper() *ErrorMapper {
+	return &ErrorMapper{}
+}
+
+// Is maps errors matching target under errors.Is with fn
+func (m *ErrorMapper) Is(target error, fn ErrorMapFunc) *ErrorMapper {
+	m.rules = append(m.rules, errorRule{matches: func(err error) bool { return errors.Is(err, target) }, mapTo: fn})
+	return m
+}
+
+// As maps errors with a link assignable to the type target points to, as
+// errors.As would find it, with fn. Pass a typed nil pointer such as
+// (**circuitOpenError)(nil).
+func (m *ErrorMapper) As(target interface{}, fn ErrorMapFunc) *ErrorMapper {
+	typ := reflect.TypeOf(target).Elem()
+	m.rules = append(m.rules, errorRule{matches: func(err error) bool { return errors.As(err, reflect.New(typ).Interface()) }, mapTo: fn})
+	return m
+}
+
+// Map returns the APIError to answer err with
+func (m *ErrorMapper) Map(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	for _, r := range m.rules {
+		if r.matches(err) {
+			status, e := r.mapTo(err)
+			e.HTTPStatus, e.Err = status, err
+			return &e
+		}
+	}
+	return &APIError{HTTPStatus: http.StatusInternalServerError, ErrorCode: "internal", Message: "internal error", Err: err}
+}
+
+// Write answers req with err mapped by m, its message translated to the
+// request's locale
+func (m *ErrorMapper) Write(w http.ResponseWriter, req *http.Request, err error) {
+	e := *m.Map(err)
+	e.Message = localize(req, e.Message)
+	writeAPIError(w, &e)
+}
+
+// mapStatus returns an ErrorMapFunc answering status with a fixed code and
+// message
+func mapStatus(status int, code, msg string) ErrorMapFunc {
+	return func(error) (int, APIError) {
+		return status, APIError{ErrorCode: code, Message: msg}
+	}
+}
+
+// DefaultErrorMapper maps the errors this package defines. writeError and
+// the account handlers use it; register further rules on it at startup.
+var DefaultErrorMapper = NewErrorMapper().
+	As((**ValidationError)(nil), func(err error) (int, APIError) {
+		var invalid *ValidationError
+		errors.As(err, &invalid)
+		return http.StatusBadRequest, APIError{ErrorCode: "validation_failed", Message: "validation failed", Fields: invalid.Fields}
+	}).
+	Is(ErrNotFound, mapStatus(http.StatusNotFound, "not_found", "not found")).
+	Is(ErrAlreadyExists, mapStatus(http.StatusConflict, "conflict", "already exists")).
+	Is(ErrNoTenant, mapStatus(http.StatusBadRequest, "tenant_required", "tenant id required")).
+	Is(ErrUnauthorized, mapStatus(http.StatusUnauthorized, "unauthorized", "unauthorized")).
+	Is(ErrForbidden, mapStatus(http.StatusForbidden, "forbidden", "forbidden")).
+	Is(ErrPreconditionFailed, mapStatus(http.StatusPreconditionFailed, "precondition_failed", "precondition failed")).
+	Is(ErrCircuitOpen, mapStatus(http.StatusServiceUnavailable, "unavailable", "service unavailable")).
+	Is(ErrBodyReadTimeout, mapStatus(http.StatusRequestTimeout, "request_timeout", "request body read timed out"))
+
+// apiErrorFor maps err onto the response it should produce using
+// DefaultErrorMapper
+func apiErrorFor(err error) *APIError {
+	return DefaultErrorMapper.Map(err)
+}
+
+// writeError writes err as a JSON APIError
+func writeError(w http.ResponseWriter, err error) {
+	writeAPIError(w, apiErrorFor(err))
+}
+
+// writeAPIError writes e. Unexpected errors are logged here, since the
+// client only sees a generic 500. An open circuit also sets Retry-After.
+func writeAPIError(w http.ResponseWriter, e *APIError) {
+	if e.HTTPStatus == http.StatusInternalServerError {
+		fmt.Println("internal error:", e.Err)
+	}
+	if errors.Is(e.Err, ErrCircuitOpen) {
+		retry := time.Second
+		var open *circuitOpenError
+		if errors.As(e.Err, &open) {
+			retry = open.retryAfter
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+	}
+	respondJSON(w, e.HTTPStatus, e)
+}
+
+// ErrorFormat selects the body shape of error responses
+type ErrorFormat int
+
+const (
+	// ErrorFormatFlat is {"code": 401, "error": "unauthorized", "message": ...}
+	ErrorFormatFlat ErrorFormat = iota
+	// ErrorFormatJSONAPI is the JSON:API {"errors": [{"status": "401", ...}]}
+	ErrorFormatJSONAPI
+)
+
+// ErrorFormatMiddleware writes the error responses of next in format
+// rather than the flat default. Run applies it with ServerConfig.ErrorFormat.
+func ErrorFormatMiddleware(format ErrorFormat) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			next.ServeHTTP(&errorFormatWriter{ResponseWriter: w, format: format}, req)
+		})
+	}
+}
+
+// errorFormatWriter carries the format ErrorFormatMiddleware was given
+// down to errorBody
+type errorFormatWriter struct {
+	http.ResponseWriter
+	format ErrorFormat
+}
+
+func (w *errorFormatWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush passes through to the underlying writer when it supports flushing
+func (w *errorFormatWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// errorFormat is the format the nearest ErrorFormatMiddleware set for w,
+// looking through wrappers that expose Unwrap
+func errorFormat(w http.ResponseWriter) ErrorFormat {
+	for {
+		switch t := w.(type) {
+		case *errorFormatWriter:
+			return t.format
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = t.Unwrap()
+		default:
+			return ErrorFormatFlat
+		}
+	}
+}
+
+// Media type of JSON:API documents
+const jsonAPIContentType = "application/vnd.api+json"
+
+// jsonAPIError is one entry of a JSON:API errors document
+type jsonAPIError struct {
+	Status string         `json:"status"`
+	Code   string         `json:"code"`
+	Detail string         `json:"detail"`
+	Source *jsonAPISource `json:"source,omitempty"`
+}
+
+type jsonAPISource struct {
+	Pointer string `json:"pointer"`
+}
+
+type jsonAPIErrors struct {
+	Errors []jsonAPIError `json:"errors"`
+}
+
+// asJSONAPI renders e as a JSON:API errors document, with one entry per
+// invalid field when there are any
+func (e *APIError) asJSONAPI() jsonAPIErrors {
+	status := strconv.Itoa(e.HTTPStatus)
+	if len(e.Fields) == 0 {
+		return jsonAPIErrors{Errors: []jsonAPIError{{Status: status, Code: e.ErrorCode, Detail: e.Message}}}
+	}
+	names := make([]string, 0, len(e.Fields))
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	doc := jsonAPIErrors{Errors: make([]jsonAPIError, 0, len(names))}
+	for _, name := range names {
+		doc.Errors = append(doc.Errors, jsonAPIError{
+			Status: status,
+			Code:   e.ErrorCode,
+			Detail: e.Fields[name],
+			Source: &jsonAPISource{Pointer: "/" + name},
+		})
+	}
+	return doc
+}
+
+// errorBody returns the body to send for a response whose flat form is v,
+// converting errors to the format ErrorFormatMiddleware selected and setting the
+// matching Content-Type on w. Both *APIError and the older {"error": msg}
+// maps are recognized; anything else is returned as it is.
+func errorBody(w http.ResponseWriter, status int, v interface{}) interface{} {
+	if status < 400 || errorFormat(w) != ErrorFormatJSONAPI {
+		return v
+	}
+	var e *APIError
+	switch t := v.(type) {
+	case *APIError:
+		e = t
+	case map[string]string:
+		msg, ok := t["error"]
+		if !ok {
+			return v
+		}
+		e = &APIError{HTTPStatus: status, ErrorCode: statusCode(status), Message: msg}
+	default:
+		return v
+	}
+	w.Header().Set("Content-Type", jsonAPIContentType)
+	return e.asJSONAPI()
+}
+
+// statusCode derives an APIError code from an HTTP status: 400 becomes
+// "bad_request"
+func statusCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
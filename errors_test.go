@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestErrorFormatMiddleware(t *testing.T) {
+	fail := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		respondError(w, req, http.StatusNotFound, "account not found")
+	})
+	wrapped := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			next.ServeHTTP(wrapResponseWriter(w), req)
+		})
+	}
+	tests := []struct {
+		name        string
+		handler     http.Handler
+		wantJSONAPI bool
+	}{
+		{"flat by default", fail, false},
+		{"flat", ErrorFormatMiddleware(ErrorFormatFlat)(fail), false},
+		{"jsonapi", ErrorFormatMiddleware(ErrorFormatJSONAPI)(fail), true},
+		{"through a wrapped writer", ErrorFormatMiddleware(ErrorFormatJSONAPI)(wrapped(fail)), true},
+		{"through a timeout", ErrorFormatMiddleware(ErrorFormatJSONAPI)(TimeoutMiddleware(time.Second)(fail)), true},
+		{"nearest wins", ErrorFormatMiddleware(ErrorFormatJSONAPI)(ErrorFormatMiddleware(ErrorFormatFlat)(fail)), false},
+		{"router middleware", func() http.Handler {
+			r := mux.NewRouter()
+			r.Handle("/", fail)
+			r.Use(wrapped)
+			return ErrorFormatMiddleware(ErrorFormatJSONAPI)(r)
+		}(), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			var body map[string]json.RawMessage
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode %q: %v", rec.Body, err)
+			}
+			_, jsonAPI := body["errors"]
+			if jsonAPI != tt.wantJSONAPI {
+				t.Errorf("body %s, want JSON:API %v", rec.Body, tt.wantJSONAPI)
+			}
+			if jsonAPI && rec.Header().Get("Content-Type") != jsonAPIContentType {
+				t.Errorf("Content-Type = %q", rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestErrorFormatMiddlewareSuccess(t *testing.T) {
+	h := ErrorFormatMiddleware(ErrorFormatJSONAPI)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		respondJSON(w, http.StatusOK, map[string]string{"error": "not an error"})
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if strings.Contains(rec.Body.String(), `"errors"`) {
+		t.Errorf("success body rewritten: %s", rec.Body)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+		wantMsg    string
+	}{
+		{"not found", ErrNotFound, http.StatusNotFound, "not_found", "not found"},
+		{"wrapped not found", fmt.Errorf("lookup 1: %w", ErrNotFound), http.StatusNotFound, "not_found", "not found"},
+		{"unauthorized", ErrUnauthorized, http.StatusUnauthorized, "unauthorized", "unauthorized"},
+		{"forbidden", ErrForbidden, http.StatusForbidden, "forbidden", "forbidden"},
+		{"unknown", errors.New("db password is hunter2"), http.StatusInternalServerError, "internal", "internal error"},
+		{"explicit APIError", &APIError{HTTPStatus: http.StatusTeapot, ErrorCode: "teapot", Message: "short and stout"}, http.StatusTeapot, "teapot", "short and stout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			captureStdout(t, func() { writeError(rec, tt.err) })
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var body struct {
+				Code    int    `json:"code"`
+				Error   string `json:"error"`
+				Message string `json:"message"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Code != tt.wantStatus || body.Error != tt.wantCode || body.Message != tt.wantMsg {
+				t.Errorf("body = %+v, want {%d %s %s}", body, tt.wantStatus, tt.wantCode, tt.wantMsg)
+			}
+		})
+	}
+}
+
+// quotaError is a domain error matched by type rather than identity
+type quotaError struct{ limit int }
+
+func (e *quotaError) Error() string { return fmt.Sprintf("quota of %d exceeded", e.limit) }
+
+func TestErrorMapper(t *testing.T) {
+	errSuspended := errors.New("suspended")
+	m := NewErrorMapper().
+		Is(errSuspended, mapStatus(http.StatusForbidden, "suspended", "account suspended")).
+		As((**quotaError)(nil), func(err error) (int, APIError) {
+			var q *quotaError
+			errors.As(err, &q)
+			return http.StatusTooManyRequests, APIError{ErrorCode: "quota", Message: fmt.Sprintf("limit is %d", q.limit)}
+		}).
+		Is(ErrNotFound, mapStatus(http.StatusNotFound, "not_found", "not found")).
+		Is(ErrNotFound, mapStatus(http.StatusGone, "gone", "gone"))
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+		wantMsg    string
+	}{
+		{"sentinel", errSuspended, http.StatusForbidden, "suspended", "account suspended"},
+		{"wrapped sentinel", fmt.Errorf("load: %w", errSuspended), http.StatusForbidden, "suspended", "account suspended"},
+		{"type", &quotaError{limit: 5}, http.StatusTooManyRequests, "quota", "limit is 5"},
+		{"wrapped type", fmt.Errorf("create: %w", &quotaError{limit: 3}), http.StatusTooManyRequests, "quota", "limit is 3"},
+		{"first matching rule wins", ErrNotFound, http.StatusNotFound, "not_found", "not found"},
+		{"APIError used as-is", &APIError{HTTPStatus: http.StatusTeapot, ErrorCode: "teapot", Message: "tea"}, http.StatusTeapot, "teapot", "tea"},
+		{"unmapped", errors.New("disk on fire"), http.StatusInternalServerError, "internal", "internal error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapped := m.Map(tt.err)
+			if mapped.HTTPStatus != tt.wantStatus || mapped.ErrorCode != tt.wantCode || mapped.Message != tt.wantMsg {
+				t.Errorf("Map = %+v, want {%d %s %s}", mapped, tt.wantStatus, tt.wantCode, tt.wantMsg)
+			}
+			if !errors.Is(mapped, tt.err) {
+				t.Errorf("mapped error does not wrap %v", tt.err)
+			}
+
+			rec := httptest.NewRecorder()
+			captureStdout(t, func() { m.Write(rec, httptest.NewRequest(http.MethodGet, "/", nil), tt.err) })
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Write status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusInternalServerError && strings.Contains(rec.Body.String(), "fire") {
+				t.Errorf("unmapped error leaked: %s", rec.Body)
+			}
+		})
+	}
+}
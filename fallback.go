@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// Warning sent with responses built from FallbackStore's cache (RFC 7234)
+const staleWarning = `110 - "Response is Stale"`
+
+// FallbackStore serves reads from a cache when the primary store fails, so
+// clients get stale data instead of a 503. Every successful primary Get
+// refreshes the cache; a Get that fails for any reason but ErrNotFound is
+// answered from the cache when it holds the account, and the request is
+// marked stale for StaleWarningMiddleware. Writes and List go only to the
+// primary.
+type FallbackStore struct {
+	primary AccountStore
+	cache   AccountStore
+}
+
+func NewFallbackStore(primary, cache AccountStore) *FallbackStore {
+	return &FallbackStore{primary: primary, cache: cache}
+}
+
+func (s *FallbackStore) Get(ctx context.Context, id string) (Account, error) {
+	a, err := s.primary.Get(ctx, id)
+	switch {
+	case err == nil:
+		if err := s.cache.Put(ctx, a); err != nil {
+			fmt.Println("fallback store: cache put:", err)
+		}
+		return a, nil
+	case errors.Is(err, ErrNotFound):
+		return a, err
+	}
+	cached, cacheErr := s.cache.Get(ctx, id)
+	if cacheErr != nil {
+		return Account{}, err
+	}
+	fmt.Println("fallback store: serving cached account after:", err)
+	markStale(ctx)
+	return cached, nil
+}
+
+func (s *FallbackStore) Put(ctx context.Context, a Account) error {
+	return s.primary.Put(ctx, a)
+}
+
+func (s *FallbackStore) Create(ctx context.Context, a Account) error {
+	return s.primary.Create(ctx, a)
+}
+
+func (s *FallbackStore) Update(ctx context.Context, id string, fn func(*Account) error) (Account, error) {
+	return s.primary.Update(ctx, id, fn)
+}
+
+func (s *FallbackStore) List(ctx context.Context, limit int, cursor string) ([]Account, string, error) {
+	return s.primary.List(ctx, limit, cursor)
+}
+
+// withStaleFlag returns a copy of ctx carrying the flag markStale sets
+func withStaleFlag(ctx context.Context, flag *int32) context.Context {
+	return context.WithValue(ctx, staleKey{}, flag)
+}
+
+// markStale flags the request ctx belongs to as answered from stale data
+func markStale(ctx context.Context) {
+	if flag, ok := ctx.Value(staleKey{}).(*int32); ok {
+		atomic.StoreInt32(flag, 1)
+	}
+}
+
+// StaleWarningMiddleware adds a Warning: 110 header to responses built
+// from data FallbackStore took from its cache. Handlers that share one
+// lookup between requests, like GetAccountHandler, only flag the request
+// whose context made it.
+func StaleWarningMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		flag := new(int32)
+		sw := &staleWriter{ResponseWriter: w, stale: flag}
+		next.ServeHTTP(sw, req.WithContext(withStaleFlag(req.Context(), flag)))
+	})
+}
+
+// staleWriter sets the Warning header as the status goes out
+type staleWriter struct {
+	http.ResponseWriter
+	stale       *int32
+	wroteHeader bool
+}
+
+func (w *staleWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if atomic.LoadInt32(w.stale) == 1 {
+			w.Header().Set("Warning", staleWarning)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *staleWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *staleWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush passes through to the underlying writer when it supports flushing
+func (w *staleWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if !w.wroteHeader {
+			w.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestFallbackStore(t *testing.T) {
+	tests := []struct {
+		name        string
+		primary     *Account // held by the primary
+		cached      *Account // already in the cache
+		primaryDown bool
+		want        int
+		wantName    string
+		wantWarning bool
+	}{
+		{"primary up", &Account{ID: "1", Name: "fresh"}, &Account{ID: "1", Name: "old"}, false, http.StatusOK, "fresh", false},
+		{"primary down, cached", &Account{ID: "1", Name: "fresh"}, &Account{ID: "1", Name: "old"}, true, http.StatusOK, "old", true},
+		{"primary down, not cached", &Account{ID: "1", Name: "fresh"}, nil, true, http.StatusInternalServerError, "", false},
+		{"not found is not a failure", nil, &Account{ID: "1", Name: "old"}, false, http.StatusNotFound, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			primary := &flakyStore{MapStore: NewMapStore(), fail: tt.primaryDown}
+			cache := NewMapStore()
+			if tt.primary != nil {
+				primary.Put(ctx, *tt.primary)
+			}
+			if tt.cached != nil {
+				cache.Put(ctx, *tt.cached)
+			}
+			store := NewFallbackStore(primary, cache)
+
+			h := StaleWarningMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				a, err := store.Get(req.Context(), mux.Vars(req)["id"])
+				if err != nil {
+					writeError(w, err)
+					return
+				}
+				respondJSON(w, http.StatusOK, a)
+			}))
+			req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/account/1", nil), map[string]string{"id": "1"})
+			rec := httptest.NewRecorder()
+			captureStdout(t, func() { h.ServeHTTP(rec, req) })
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if got := rec.Header().Get("Warning"); (got == staleWarning) != tt.wantWarning {
+				t.Errorf("Warning = %q, want stale = %v", got, tt.wantWarning)
+			}
+			if tt.wantName != "" {
+				var got Account
+				json.Unmarshal(rec.Body.Bytes(), &got)
+				if got.Name != tt.wantName {
+					t.Errorf("name = %q, want %q", got.Name, tt.wantName)
+				}
+				// whatever was served is what the cache now holds
+				if cached, _ := cache.Get(ctx, "1"); cached.Name != tt.wantName {
+					t.Errorf("cache holds %q, want %q", cached.Name, tt.wantName)
+				}
+			}
+		})
+	}
+}
+
+func TestFallbackStoreWritesSkipCache(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		write func(s *FallbackStore) error
+	}{
+		{"put", func(s *FallbackStore) error { return s.Put(ctx, Account{ID: "1", Name: "a"}) }},
+		{"create", func(s *FallbackStore) error { return s.Create(ctx, Account{ID: "1", Name: "a"}) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary, cache := NewMapStore(), NewMapStore()
+			if err := tt.write(NewFallbackStore(primary, cache)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := primary.Get(ctx, "1"); err != nil {
+				t.Errorf("primary: %v", err)
+			}
+			if _, err := cache.Get(ctx, "1"); err == nil {
+				t.Error("write reached the cache")
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"hash/fnv"
+	"net/http"
+	"sync/atomic"
+)
+
+// FeatureFlag rolls a feature out to a percentage of requests. Requests
+// are bucketed by a hash of their request id, so retries of one request
+// land on the same side while the percentage is unchanged. The zero value
+// is off, as is a nil *FeatureFlag; Set may be called while serving.
+type FeatureFlag struct {
+	percent int32
+}
+
+// Set changes the share of requests the feature is enabled for, clamped
+// to 0-100
+func (f *FeatureFlag) Set(percent int) {
+	if percent < 0 {
+		percent = 0
+	}
+	if percent > 100 {
+		percent = 100
+	}
+	atomic.StoreInt32(&f.percent, int32(percent))
+}
+
+// Percent returns the share of requests the feature is enabled for
+func (f *FeatureFlag) Percent() int {
+	if f == nil {
+		return 0
+	}
+	return int(atomic.LoadInt32(&f.percent))
+}
+
+// Enabled reports whether the feature is on for key. An empty key is only
+// enabled at 100%, since it can't be bucketed stably.
+func (f *FeatureFlag) Enabled(key string) bool {
+	p := f.Percent()
+	switch {
+	case p >= 100:
+		return true
+	case p <= 0 || key == "":
+		return false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()%100 < uint32(p)
+}
+
+// Split serves each request with on when the flag is enabled for its
+// request id, and with off otherwise
+func (f *FeatureFlag) Split(on, off http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if f.Enabled(requestID(req)) {
+			on.ServeHTTP(w, req)
+			return
+		}
+		off.ServeHTTP(w, req)
+	})
+}
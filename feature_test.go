@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFeatureFlagSplit(t *testing.T) {
+	tests := []struct {
+		name    string
+		flag    *FeatureFlag
+		percent int
+		id      string
+		want    string
+	}{
+		{"0% with id", &FeatureFlag{}, 0, "req-1", "v1"},
+		{"100% with id", &FeatureFlag{}, 100, "req-1", "v2"},
+		{"100% without id", &FeatureFlag{}, 100, "", "v2"},
+		{"50% without id", &FeatureFlag{}, 50, "", "v1"},
+		{"clamped above 100", &FeatureFlag{}, 250, "req-1", "v2"},
+		{"clamped below 0", &FeatureFlag{}, -5, "req-1", "v1"},
+		{"nil flag", nil, 0, "req-1", "v1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.flag != nil {
+				tt.flag.Set(tt.percent)
+			}
+			h := tt.flag.Split(variantHandler("v2"), variantHandler("v1"))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.id != "" {
+				req.Header.Set("X-Request-ID", tt.id)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if got := rec.Body.String(); got != tt.want {
+				t.Errorf("served by %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFeatureFlagStablePerRequestID(t *testing.T) {
+	flag := &FeatureFlag{}
+	flag.Set(30)
+	on := 0
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("req-%d", i)
+		first := flag.Enabled(id)
+		for j := 0; j < 3; j++ {
+			if flag.Enabled(id) != first {
+				t.Fatalf("%s flipped between calls", id)
+			}
+		}
+		if first {
+			on++
+		}
+	}
+	if on < 200 || on > 400 {
+		t.Errorf("%d of 1000 ids enabled at 30%%", on)
+	}
+}
+
+func variantHandler(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(name))
+	})
+}
+
+// authTransport adds an Authorization header to every request
+type authTransport string
+
+func (a authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", string(a))
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestAccountV2RolloutKeepsETag(t *testing.T) {
+	for _, percent := range []int{0, 100} {
+		t.Run(fmt.Sprintf("%d%%", percent), func(t *testing.T) {
+			flag := &FeatureFlag{}
+			flag.Set(percent)
+			srv, store := NewTestServer(t, WithAccountV2Rollout(flag))
+			store.Put(context.Background(), Account{ID: "1", Name: "a"})
+
+			remote := NewHTTPAccountStore(srv.URL, &http.Client{Transport: authTransport("1")}, 1)
+			updated, err := remote.Update(context.Background(), "1", func(a *Account) error {
+				a.Name = "b"
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+			if updated.Name != "b" {
+				t.Errorf("name = %q, want b", updated.Name)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testServerConfig collects what NewTestServer wires together
+type testServerConfig struct {
+	store   AccountStore
+	auth    AuthConfig
+	logging []LoggingOption
+
+	maintenance *AtomicBool
+	limiter     *RateLimiter
+	rollout     *FeatureFlag
+}
+
+// Option customizes NewTestServer
+type Option func(*testServerConfig)
+
+// WithStore serves accounts from store instead of a fresh MapStore
+func WithStore(store AccountStore) Option {
+	return func(c *testServerConfig) {
+		c.store = store
+	}
+}
+
+// WithAuth authenticates requests with cfg. The default accepts the legacy
+// "Authorization: <account id>" form only.
+func WithAuth(cfg AuthConfig) Option {
+	return func(c *testServerConfig) {
+		c.auth = cfg
+	}
+}
+
+// WithLogging passes opts to the access log middleware
+func WithLogging(opts ...LoggingOption) Option {
+	return func(c *testServerConfig) {
+		c.logging = opts
+	}
+}
+
+// WithMaintenance puts the routes behind MaintenanceMiddleware switched by
+// flag and mounts the admin routes that toggle it
+func WithMaintenance(flag *AtomicBool) Option {
+	return func(c *testServerConfig) {
+		c.maintenance = flag
+	}
+}
+
+// WithRateLimiter puts the routes behind l and mounts GET /admin/ratelimits.
+// l runs before the per-route authentication, so it limits by client IP.
+func WithRateLimiter(l *RateLimiter) Option {
+	return func(c *testServerConfig) {
+		c.limiter = l
+	}
+}
+
+// WithAccountV2Rollout serves the share of account reads flag selects with
+// GetAccountV2
+func WithAccountV2Rollout(flag *FeatureFlag) Option {
+	return func(c *testServerConfig) {
+		c.rollout = flag
+	}
+}
+
+// NewTestServer starts an httptest.Server running the account routes behind
+// the production middleware: access logging with panic recovery, then
+// authentication. It returns the server and the store behind it so tests
+// can seed accounts. The server is closed when the test ends.
+func NewTestServer(t testing.TB, opts ...Option) (*httptest.Server, AccountStore) {
+	t.Helper()
+	cfg := &testServerConfig{store: NewMapStore()}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	h, err := newAppHandler(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv, cfg.store
+}
+
+// newAppHandler builds the router NewTestServer serves
+func newAppHandler(cfg *testServerConfig) (http.Handler, error) {
+	r := NewRouter()
+	chain := []DeclaredMiddleware{{Name: "Logging", Func: ToMux(LoggingFunc(cfg.logging...))}}
+	if cfg.maintenance != nil {
+		chain = append(chain, DeclaredMiddleware{Name: "Maintenance", Func: MaintenanceMiddleware(cfg.maintenance, time.Minute)})
+	}
+	if cfg.limiter != nil {
+		chain = append(chain, DeclaredMiddleware{Name: "RateLimit", Func: cfg.limiter.Middleware})
+	}
+	if err := UseChain(r, chain...); err != nil {
+		return nil, err
+	}
+	err := RegisterAdminRoutes(r, cfg.auth, AdminRoutes{Maintenance: cfg.maintenance, RateLimiter: cfg.limiter, Routes: r})
+	if err != nil {
+		return nil, err
+	}
+
+	RegisterAccountRoutes(r, cfg.store, cfg.auth, cfg.rollout)
+	return r, nil
+}
+
+func TestNewTestServer(t *testing.T) {
+	tests := []struct {
+		name string
+		opts func() []Option
+		auth string
+		want []int // statuses of successive GET /account/1 requests
+	}{
+		{"owner", func() []Option { return nil }, "1", []int{http.StatusOK}},
+		{"other caller", func() []Option { return nil }, "2", []int{http.StatusForbidden}},
+		{"anonymous", func() []Option { return nil }, "", []int{http.StatusUnauthorized}},
+		{"maintenance", func() []Option {
+			flag := &AtomicBool{}
+			flag.Store(true)
+			return []Option{WithMaintenance(flag)}
+		}, "1", []int{http.StatusServiceUnavailable}},
+		{"rate limited", func() []Option {
+			return []Option{WithRateLimiter(NewRateLimiter(RateLimitConfig{PerIP: RateLimit{Requests: 1, Window: time.Hour}}))}
+		}, "1", []int{http.StatusOK, http.StatusTooManyRequests}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, store := NewTestServer(t, tt.opts()...)
+			store.Put(context.Background(), Account{ID: "1", Name: "a"})
+			for i, want := range tt.want {
+				req, _ := http.NewRequest(http.MethodGet, srv.URL+"/account/1", nil)
+				if tt.auth != "" {
+					req.Header.Set("Authorization", tt.auth)
+				}
+				resp, err := srv.Client().Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != want {
+					t.Errorf("request %d: status = %d, want %d", i+1, resp.StatusCode, want)
+				}
+			}
+		})
+	}
+}
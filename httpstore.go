@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// httpAccountStore is an AccountStore backed by a remote account service
+// speaking this package's own HTTP API. Reads are retried on connection
+// errors and 5xx responses with jittered exponential backoff; writes are
+// never retried, since a failed attempt may still have been applied.
+type httpAccountStore struct {
+	baseURL string
+	client  *http.Client
+
+	maxAttempts int
+	baseBackoff time.Duration
+}
+
+// NewHTTPAccountStore returns an AccountStore calling the service at
+// baseURL through client (http.DefaultClient if nil). GETs are tried up to
+// maxAttempts times.
+func NewHTTPAccountStore(baseURL string, client *http.Client, maxAttempts int) AccountStore {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &httpAccountStore{baseURL: baseURL, client: client, maxAttempts: maxAttempts, baseBackoff: 50 * time.Millisecond}
+}
+
+func (s *httpAccountStore) Get(ctx context.Context, id string) (Account, error) {
+	a, _, err := s.get(ctx, id)
+	return a, err
+}
+
+// get fetches an account along with its ETag
+func (s *httpAccountStore) get(ctx context.Context, id string) (Account, string, error) {
+	var a Account
+	resp, err := s.retryGet(ctx, "/account/"+url.PathEscape(id))
+	if err != nil {
+		return Account{}, "", err
+	}
+	defer resp.Body.Close()
+	if err := decodeData(resp, &a); err != nil {
+		return Account{}, "", err
+	}
+	return a, resp.Header.Get("ETag"), nil
+}
+
+// Put creates a, or replaces the account already stored under its id
+func (s *httpAccountStore) Put(ctx context.Context, a Account) error {
+	resp, err := s.send(ctx, http.MethodPost, "/accounts", "", a)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusConflict {
+		_, err := s.Update(ctx, a.ID, func(stored *Account) error {
+			*stored = a
+			return nil
+		})
+		return err
+	}
+	return decodeData(resp, nil)
+}
+
+// Create creates a, failing with ErrAlreadyExists if its id is taken
+func (s *httpAccountStore) Create(ctx context.Context, a Account) error {
+	resp, err := s.send(ctx, http.MethodPost, "/accounts", "", a)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decodeData(resp, nil)
+}
+
+// Update reads the account, applies fn and writes it back conditioned on
+// the ETag it read. A concurrent change makes it fail with
+// ErrPreconditionFailed rather than overwrite.
+func (s *httpAccountStore) Update(ctx context.Context, id string, fn func(*Account) error) (Account, error) {
+	a, etag, err := s.get(ctx, id)
+	if err != nil {
+		return Account{}, err
+	}
+	if err := fn(&a); err != nil {
+		return Account{}, err
+	}
+	resp, err := s.send(ctx, http.MethodPut, "/account/"+url.PathEscape(id), etag, a)
+	if err != nil {
+		return Account{}, err
+	}
+	defer resp.Body.Close()
+	var updated Account
+	if err := decodeData(resp, &updated); err != nil {
+		return Account{}, err
+	}
+	return updated, nil
+}
+
+func (s *httpAccountStore) List(ctx context.Context, limit int, cursor string) ([]Account, string, error) {
+	q := url.Values{"limit": {strconv.Itoa(limit)}}
+	if cursor != "" {
+		q.Set("cursor", base64.RawURLEncoding.EncodeToString([]byte(cursor)))
+	}
+	resp, err := s.retryGet(ctx, "/accounts?"+q.Encode())
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	var page accountPage
+	if err := decodeData(resp, &page); err != nil {
+		return nil, "", err
+	}
+	next, err := base64.RawURLEncoding.DecodeString(page.NextCursor)
+	if err != nil {
+		return nil, "", fmt.Errorf("account service: bad cursor: %w", err)
+	}
+	return page.Items, string(next), nil
+}
+
+// retryGet GETs path, retrying connection errors and 5xx responses. The
+// last response is returned whatever its status.
+func (s *httpAccountStore) retryGet(ctx context.Context, path string) (*http.Response, error) {
+	backoff := s.baseBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := s.send(ctx, http.MethodGet, path, "", nil)
+		retryable := err != nil || resp.StatusCode >= 500
+		if !retryable || attempt >= s.maxAttempts || ctx.Err() != nil {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		// full jitter: sleep anywhere up to the current backoff
+		t := time.NewTimer(time.Duration(rand.Int63n(int64(backoff) + 1)))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+		backoff *= 2
+	}
+}
+
+// send makes one request, encoding body as JSON when non-nil
+func (s *httpAccountStore) send(ctx context.Context, method, path, ifMatch string, body interface{}) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.baseURL+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	return s.client.Do(req)
+}
+
+// decodeData unwraps the success envelope into out, or maps an error
+// response onto the store's errors
+func decodeData(resp *http.Response, out interface{}) error {
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case resp.StatusCode == http.StatusPreconditionFailed:
+		return ErrPreconditionFailed
+	case resp.StatusCode == http.StatusConflict:
+		return ErrAlreadyExists
+	case resp.StatusCode/100 != 2:
+		var e APIError
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&e)
+		e.HTTPStatus = resp.StatusCode
+		if e.Message == "" {
+			e.Message = http.StatusText(resp.StatusCode)
+		}
+		return fmt.Errorf("account service: %d %s", e.HTTPStatus, e.Message)
+	case out == nil:
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&successEnvelope{Data: out}); err != nil {
+		return fmt.Errorf("account service: %w", err)
+	}
+	return nil
+}
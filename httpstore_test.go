@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPAccountStoreRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int    // requests answered with failure before the backend recovers
+		failWith  string // "500" or "drop" (close the connection)
+		call      func(ctx context.Context, s AccountStore) error
+		wantCalls int32
+		wantErr   bool
+	}{
+		{"get recovers from 5xx", 2, "500", getAccount, 3, false},
+		{"get recovers from dropped connection", 1, "drop", getAccount, 2, false},
+		{"get gives up", 5, "500", getAccount, 3, true},
+		{"list recovers", 1, "500", listAccounts, 2, false},
+		{"create not retried", 1, "500", createAccount, 1, true},
+		{"create not retried on dropped connection", 1, "drop", createAccount, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if n := atomic.AddInt32(&calls, 1); int(n) <= tt.failures {
+					if tt.failWith == "drop" {
+						conn, _, _ := w.(http.Hijacker).Hijack()
+						conn.Close()
+						return
+					}
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				switch req.Method {
+				case http.MethodGet:
+					if req.URL.Path == "/accounts" {
+						RespondJSON(w, req, http.StatusOK, accountPage{Items: []Account{{ID: "1"}}})
+						return
+					}
+					RespondJSON(w, req, http.StatusOK, Account{ID: "1", Name: "a"})
+				default:
+					RespondJSON(w, req, http.StatusCreated, Account{ID: "1"})
+				}
+			}))
+			defer srv.Close()
+			s := NewHTTPAccountStore(srv.URL, srv.Client(), 3)
+			s.(*httpAccountStore).baseBackoff = time.Millisecond
+
+			err := tt.call(context.Background(), s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error = %v", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt32(&calls); got != tt.wantCalls {
+				t.Errorf("backend calls = %d, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func getAccount(ctx context.Context, s AccountStore) error {
+	_, err := s.Get(ctx, "1")
+	return err
+}
+
+func listAccounts(ctx context.Context, s AccountStore) error {
+	_, _, err := s.List(ctx, 10, "")
+	return err
+}
+
+func createAccount(ctx context.Context, s AccountStore) error {
+	return s.Create(ctx, Account{ID: "1"})
+}
+
+func TestHTTPAccountStoreCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	s := NewHTTPAccountStore(srv.URL, srv.Client(), 100)
+	s.(*httpAccountStore).baseBackoff = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := s.Get(ctx, "1")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Get took %v after its context ended", elapsed)
+	}
+}
+
+func TestHTTPAccountStoreErrors(t *testing.T) {
+	tests := []struct {
+		status  int
+		wantErr error
+	}{
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusConflict, ErrAlreadyExists},
+		{http.StatusPreconditionFailed, ErrPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+			if err := createAccount(context.Background(), NewHTTPAccountStore(srv.URL, srv.Client(), 3)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/text/language"
+)
+
+// Locales with a message catalog. The first is the default.
+var supportedLocales = []language.Tag{language.English, language.French, language.German}
+
+// errorCatalog translates error messages, keyed by their English text.
+// Messages missing from a locale fall back to English.
+var errorCatalog = map[language.Tag]map[string]string{
+	language.French: {
+		"account not found":                    "compte introuvable",
+		"account store unavailable":            "stockage des comptes indisponible",
+		"empty patch":                          "modification vide",
+		"internal error":                       "erreur interne",
+		"invalid cursor":                       "curseur invalide",
+		"limit must be a non-negative integer": "limit doit être un entier positif ou nul",
+		"malformed JSON":                       "JSON mal formé",
+	},
+	language.German: {
+		"account not found":                    "Konto nicht gefunden",
+		"account store unavailable":            "Kontospeicher nicht verfügbar",
+		"empty patch":                          "leere Änderung",
+		"internal error":                       "interner Fehler",
+		"invalid cursor":                       "ungültiger Cursor",
+		"limit must be a non-negative integer": "limit muss eine nicht negative ganze Zahl sein",
+		"malformed JSON":                       "fehlerhaftes JSON",
+	},
+}
+
+// WithLocale returns a copy of ctx carrying tag
+func WithLocale(ctx context.Context, tag language.Tag) context.Context {
+	return context.WithValue(ctx, localeKey{}, tag)
+}
+
+// LocaleFromContext returns the locale chosen by LocaleMiddleware, or the
+// default locale if it hasn't run
+func LocaleFromContext(ctx context.Context) language.Tag {
+	if tag, ok := ctx.Value(localeKey{}).(language.Tag); ok {
+		return tag
+	}
+	return supportedLocales[0]
+}
+
+// I18nMiddleware is LocaleMiddleware with the first of supported (or of the
+// built-in catalog's locales when none are given) as the default
+func I18nMiddleware(supported ...language.Tag) mux.MiddlewareFunc {
+	if len(supported) == 0 {
+		supported = supportedLocales
+	}
+	return LocaleMiddleware(supported, supported[0])
+}
+
+// LocaleMiddleware picks the best match for the Accept-Language header among
+// supported and stores it in the request context for LocaleFromContext. A
+// missing, malformed, or unmatched header gets def. The choice is echoed in
+// Content-Language.
+func LocaleMiddleware(supported []language.Tag, def language.Tag) mux.MiddlewareFunc {
+	matcher := language.NewMatcher(supported)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			tag := def
+			if prefs, _, err := language.ParseAcceptLanguage(req.Header.Get("Accept-Language")); err == nil && len(prefs) > 0 && len(supported) > 0 {
+				if _, i, conf := matcher.Match(prefs...); conf != language.No {
+					tag = supported[i]
+				}
+			}
+			w.Header().Set("Content-Language", tag.String())
+			next.ServeHTTP(w, req.WithContext(WithLocale(req.Context(), tag)))
+		})
+	}
+}
+
+// localize returns msg in the request's locale, or unchanged when the
+// catalog has no translation
+func localize(req *http.Request, msg string) string {
+	if t, ok := errorCatalog[LocaleFromContext(req.Context())][msg]; ok {
+		return t
+	}
+	return msg
+}
+
+// respondError writes an APIError for status with msg translated to the
+// request's locale
+func respondError(w http.ResponseWriter, req *http.Request, status int, msg string) {
+	respondJSON(w, status, &APIError{HTTPStatus: status, ErrorCode: statusCode(status), Message: localize(req, msg)})
+}
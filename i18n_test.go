@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/text/language"
+)
+
+func TestI18nMiddleware(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		wantLocale     string
+		wantMessage    string
+	}{
+		{"exact match", "fr", "fr", "compte introuvable"},
+		{"best match by region", "de-CH", "de", "Konto nicht gefunden"},
+		{"weighted preference", "es;q=0.9, de;q=0.8, fr;q=0.5", "de", "Konto nicht gefunden"},
+		{"unsupported", "ja", "en", "account not found"},
+		{"missing", "", "en", "account not found"},
+		{"malformed", ";;;q=x", "en", "account not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := I18nMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				respondError(w, req, http.StatusNotFound, "account not found")
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/account/1", nil)
+			if tt.acceptLanguage != "" {
+				req.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Content-Language"); got != tt.wantLocale {
+				t.Errorf("Content-Language = %q, want %q", got, tt.wantLocale)
+			}
+			var body APIError
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			if body.Message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", body.Message, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestLocalizeFallsBackToEnglish(t *testing.T) {
+	tests := []struct {
+		name   string
+		locale language.Tag
+		msg    string
+		want   string
+	}{
+		{"translated", language.French, "malformed JSON", "JSON mal formé"},
+		{"not in catalog", language.French, "some new message", "some new message"},
+		{"english", language.English, "malformed JSON", "malformed JSON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req = req.WithContext(WithLocale(req.Context(), tt.locale))
+			if got := localize(req, tt.msg); got != tt.want {
+				t.Errorf("localize = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocaleMiddleware(t *testing.T) {
+	supported := []language.Tag{language.AmericanEnglish, language.French, language.BrazilianPortuguese}
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		want           language.Tag
+	}{
+		{"exact match", "fr", language.French},
+		{"best match fallback", "pt-PT", language.BrazilianPortuguese},
+		{"missing header", "", language.French},
+		{"unsupported", "zh", language.French},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got language.Tag
+			h := LocaleMiddleware(supported, language.French)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				got = LocaleFromContext(req.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.acceptLanguage != "" {
+				req.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.want {
+				t.Errorf("locale = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocaleFromContextDefault(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if got := LocaleFromContext(req.Context()); got != language.English {
+		t.Errorf("LocaleFromContext without middleware = %v, want en", got)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ErrIdempotencyInFlight is returned by IdempotencyStore.Begin while an
+// earlier request with the same key is still being served
+var ErrIdempotencyInFlight = errors.New("idempotency key in flight")
+
+// Largest request body IdempotencyMiddleware fingerprints; bigger ones get a 413
+const maxIdempotentBody = 1 << 20
+
+// StoredResponse is a response kept for replay to a retried request
+type StoredResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+
+	// Fingerprint identifies the request body the response answered, so a
+	// key reused for a different body can be refused
+	Fingerprint string
+}
+
+// IdempotencyStore tracks Idempotency-Key values and their responses
+type IdempotencyStore interface {
+	// Begin claims key. It returns the saved response when the key has
+	// already completed, ErrIdempotencyInFlight when it is claimed but not
+	// completed, and nil, nil when the caller now holds the claim.
+	Begin(ctx context.Context, key string) (*StoredResponse, error)
+	// Complete saves the response for a key claimed with Begin
+	Complete(ctx context.Context, key string, resp StoredResponse) error
+	// Release drops a claim without saving anything, so the key can be retried
+	Release(ctx context.Context, key string) error
+}
+
+type idempotencyEntry struct {
+	resp    *StoredResponse // nil while in flight
+	expires time.Time
+}
+
+// MapIdempotencyStore is an in-memory IdempotencyStore. Completed responses
+// are replayed for TTL after they were saved; expired ones are swept out as
+// new keys are claimed.
+type MapIdempotencyStore struct {
+	TTL   time.Duration
+	Clock Clock // defaults to the real clock
+
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+}
+
+// NewMapIdempotencyStore returns an empty store keeping responses for ttl
+func NewMapIdempotencyStore(ttl time.Duration) *MapIdempotencyStore {
+	return &MapIdempotencyStore{TTL: ttl, entries: map[string]*idempotencyEntry{}}
+}
+
+func (s *MapIdempotencyStore) Begin(ctx context.Context, key string) (*StoredResponse, error) {
+	now := clockOrReal(s.Clock).Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, e := range s.entries {
+		if e.resp != nil && !now.Before(e.expires) {
+			delete(s.entries, k)
+		}
+	}
+	if e, ok := s.entries[key]; ok {
+		if e.resp == nil {
+			return nil, ErrIdempotencyInFlight
+		}
+		return e.resp, nil
+	}
+	s.entries[key] = &idempotencyEntry{}
+	return nil, nil
+}
+
+func (s *MapIdempotencyStore) Complete(ctx context.Context, key string, resp StoredResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = &idempotencyEntry{resp: &resp, expires: clockOrReal(s.Clock).Now().Add(s.TTL)}
+	return nil
+}
+
+func (s *MapIdempotencyStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+// IdempotencyMiddleware makes POST requests carrying an Idempotency-Key safe
+// to retry. Keys are scoped to the caller, method and path, so one client
+// can never be replayed another's response. The first response for a key
+// is saved and replayed verbatim, with "Idempotent-Replayed: true", for
+// later requests with that key and the same body; reusing the key with a
+// different body gets a 422, and a duplicate arriving while the first is
+// still running gets a 409. 5xx responses, panics and requests that
+// finished without writing a response release the key instead, since
+// retrying them may succeed. Other requests pass through.
+func IdempotencyMiddleware(store IdempotencyStore) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			key := req.Header.Get("Idempotency-Key")
+			if req.Method != http.MethodPost || key == "" {
+				next.ServeHTTP(w, req)
+				return
+			}
+			body, err := io.ReadAll(io.LimitReader(req.Body, maxIdempotentBody+1))
+			req.Body.Close()
+			switch {
+			case err != nil:
+				respondJSON(w, http.StatusBadRequest, map[string]string{"error": "could not read body"})
+				return
+			case len(body) > maxIdempotentBody:
+				respondJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": "request body too large"})
+				return
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			sum := sha256.Sum256(body)
+			fingerprint := hex.EncodeToString(sum[:])
+
+			ctx := req.Context()
+			key = idempotencyScope(req) + key
+			saved, err := store.Begin(ctx, key)
+			switch {
+			case errors.Is(err, ErrIdempotencyInFlight):
+				respondJSON(w, http.StatusConflict, map[string]string{"error": "request with this idempotency key is in progress"})
+				return
+			case err != nil:
+				fmt.Println("idempotency:", err)
+				respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+				return
+			case saved != nil && saved.Fingerprint != fingerprint:
+				respondJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "idempotency key reused with a different request body"})
+				return
+			case saved != nil:
+				replay(w, saved)
+				return
+			}
+
+			rw := wrapResponseWriter(w)
+			var out bytes.Buffer
+			rw.tee = &out
+			completed := false
+			defer func() {
+				if !completed {
+					store.Release(ctx, key)
+				}
+			}()
+
+			next.ServeHTTP(rw, req)
+
+			if !rw.Committed() || rw.Status() >= 500 {
+				return
+			}
+			resp := StoredResponse{Status: rw.Status(), Header: w.Header().Clone(), Body: out.Bytes(), Fingerprint: fingerprint}
+			if err := store.Complete(ctx, key, resp); err != nil {
+				fmt.Println("idempotency:", err)
+				return
+			}
+			completed = true
+		})
+	}
+}
+
+// idempotencyScope prefixes an Idempotency-Key with who sent it and where:
+// the authenticated principal, or a hash of the credentials when the
+// middleware runs before authentication, then the method and path
+func idempotencyScope(req *http.Request) string {
+	caller := ""
+	if p, ok := PrincipalFromContext(req.Context()); ok {
+		caller = "principal:" + p.ID
+	} else if auth := req.Header.Get("Authorization"); auth != "" {
+		sum := sha256.Sum256([]byte(auth))
+		caller = "credentials:" + hex.EncodeToString(sum[:])
+	}
+	return caller + "\n" + req.Method + " " + req.URL.Path + "\n"
+}
+
+// replay writes a saved response back out, marked as a replay
+func replay(w http.ResponseWriter, resp *StoredResponse) {
+	w.Header().Set("Idempotent-Replayed", "true")
+	writeStoredResponse(w, resp)
+}
+
+// writeStoredResponse writes resp to w, adding to any headers already set
+func writeStoredResponse(w http.ResponseWriter, resp *StoredResponse) {
+	h := w.Header()
+	for k, v := range resp.Header {
+		h[k] = append([]string(nil), v...)
+	}
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+}
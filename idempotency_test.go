@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type idempotentRequest struct {
+	auth, path, body string
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	first := idempotentRequest{auth: "123", path: "/accounts", body: `{"id":"1"}`}
+	tests := []struct {
+		name         string
+		second       idempotentRequest
+		status       int // what the handler answers the first request with
+		wantCalls    int
+		wantReplayed bool
+		wantStatus   int
+	}{
+		{"same caller and body", first, http.StatusCreated, 1, true, http.StatusCreated},
+		{"other caller", idempotentRequest{"999", first.path, first.body}, http.StatusCreated, 2, false, http.StatusCreated},
+		{"other path", idempotentRequest{first.auth, "/accounts/bulk", first.body}, http.StatusCreated, 2, false, http.StatusCreated},
+		{"other body", idempotentRequest{first.auth, first.path, `{"id":"2"}`}, http.StatusCreated, 1, false, http.StatusUnprocessableEntity},
+		{"server error is retried", first, http.StatusInternalServerError, 2, false, http.StatusInternalServerError},
+		{"nothing written is retried", first, 0, 2, false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			h := IdempotencyMiddleware(NewMapIdempotencyStore(time.Hour))(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				calls++
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+			}))
+
+			send := func(r idempotentRequest) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, r.path, strings.NewReader(r.body))
+				req.Header.Set("Authorization", r.auth)
+				req.Header.Set("Idempotency-Key", "k1")
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				return rec
+			}
+			send(first)
+			rec := send(tt.second)
+
+			if calls != tt.wantCalls {
+				t.Errorf("handler calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if replayed := rec.Header().Get("Idempotent-Replayed") == "true"; replayed != tt.wantReplayed {
+				t.Errorf("replayed = %v, want %v", replayed, tt.wantReplayed)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestMapIdempotencyStoreExpiry(t *testing.T) {
+	clock := newFakeClock(time.Unix(0, 0))
+	store := NewMapIdempotencyStore(time.Minute)
+	store.Clock = clock
+	ctx := context.Background()
+
+	if _, err := store.Begin(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	store.Complete(ctx, "a", StoredResponse{Status: http.StatusCreated})
+	if saved, _ := store.Begin(ctx, "a"); saved == nil {
+		t.Fatal("completed key not replayed within TTL")
+	}
+
+	clock.Advance(time.Minute)
+	if saved, err := store.Begin(ctx, "b"); saved != nil || err != nil {
+		t.Fatalf("Begin(b) = %v, %v", saved, err)
+	}
+	if _, ok := store.entries["a"]; ok {
+		t.Error("expired entry not swept")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Latency buckets grow geometrically by this factor, so a percentile read
+// from them is within 5% of the true value
+const latencyBucketGrowth = 1.05
+
+// Upper bounds, in microseconds, of the latency buckets: 1µs up to about
+// an hour. Anything slower lands in the last bucket.
+var latencyBounds = func() []float64 {
+	var bounds []float64
+	for b := 1.0; b < float64(time.Hour/time.Microsecond); b *= latencyBucketGrowth {
+		bounds = append(bounds, b)
+	}
+	return bounds
+}()
+
+// latencyHistogram counts observations into log-scaled buckets, trading
+// exactness for constant memory, much like an HDR histogram
+type latencyHistogram struct {
+	counts []uint64
+	total  uint64
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	us := float64(d) / float64(time.Microsecond)
+	i := sort.SearchFloat64s(latencyBounds, us)
+	if i == len(latencyBounds) {
+		i--
+	}
+	h.counts[i]++
+	h.total++
+}
+
+// quantile returns the upper bound of the bucket holding the q'th
+// observation
+func (h *latencyHistogram) quantile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.total)))
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			return time.Duration(latencyBounds[i] * float64(time.Microsecond))
+		}
+	}
+	return time.Duration(latencyBounds[len(latencyBounds)-1] * float64(time.Microsecond))
+}
+
+// LatencyTracker records request latency per route template and reports
+// percentiles, for a quick look without a Prometheus server
+type LatencyTracker struct {
+	mu     sync.Mutex
+	routes map[string]*latencyHistogram
+}
+
+func NewLatencyTracker() *LatencyTracker {
+	return &LatencyTracker{routes: map[string]*latencyHistogram{}}
+}
+
+// Observe records one request to route taking d
+func (t *LatencyTracker) Observe(route string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h, ok := t.routes[route]
+	if !ok {
+		h = &latencyHistogram{counts: make([]uint64, len(latencyBounds))}
+		t.routes[route] = h
+	}
+	h.observe(d)
+}
+
+// RouteStats summarizes the latency of one route
+type RouteStats struct {
+	Count uint64  `json:"count"`
+	P50ms float64 `json:"p50_ms"`
+	P95ms float64 `json:"p95_ms"`
+	P99ms float64 `json:"p99_ms"`
+}
+
+// Stats returns the percentiles of every route seen so far
+func (t *LatencyTracker) Stats() map[string]RouteStats {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make(map[string]RouteStats, len(t.routes))
+	for route, h := range t.routes {
+		out[route] = RouteStats{
+			Count: h.total,
+			P50ms: ms(h.quantile(0.50)),
+			P95ms: ms(h.quantile(0.95)),
+			P99ms: ms(h.quantile(0.99)),
+		}
+	}
+	return out
+}
+
+// Middleware times each request under its RouteTemplate, so /account/1
+// and /account/2 are both counted as /account/{id}. Register it with r.Use
+// on a router from NewRouter so the template is known.
+func (t *LatencyTracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		defer func() {
+			t.Observe(RouteTemplate(req.Context()), time.Since(start))
+		}()
+		next.ServeHTTP(w, req)
+	})
+}
+
+// StatsHandler serves Stats as JSON, e.g. at /stats
+func (t *LatencyTracker) StatsHandler(rw http.ResponseWriter, req *http.Request) {
+	respondJSON(rw, http.StatusOK, t.Stats())
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLatencyTrackerPercentiles(t *testing.T) {
+	tests := []struct {
+		name          string
+		latencies     func(i int) time.Duration // latency of the i'th of 1000 requests
+		p50, p95, p99 float64                   // want, in ms
+	}{
+		{"uniform 1..1000ms", func(i int) time.Duration { return time.Duration(i+1) * time.Millisecond }, 500, 950, 990},
+		{"constant", func(int) time.Duration { return 20 * time.Millisecond }, 20, 20, 20},
+		{"slow tail", func(i int) time.Duration {
+			if i >= 980 {
+				return 2 * time.Second
+			}
+			return 5 * time.Millisecond
+		}, 5, 5, 2000},
+		{"sub-millisecond", func(i int) time.Duration { return time.Duration(i+1) * time.Microsecond }, 0.5, 0.95, 0.99},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewLatencyTracker()
+			for i := 0; i < 1000; i++ {
+				tracker.Observe("/account/{id}", tt.latencies(i))
+			}
+			got := tracker.Stats()["/account/{id}"]
+			if got.Count != 1000 {
+				t.Errorf("count = %d, want 1000", got.Count)
+			}
+			for _, c := range []struct {
+				name      string
+				got, want float64
+			}{{"p50", got.P50ms, tt.p50}, {"p95", got.P95ms, tt.p95}, {"p99", got.P99ms, tt.p99}} {
+				if math.Abs(c.got-c.want) > c.want*(latencyBucketGrowth-1) {
+					t.Errorf("%s = %.3fms, want %.3fms within 5%%", c.name, c.got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLatencyTrackerMiddleware(t *testing.T) {
+	tracker := NewLatencyTracker()
+	r := NewRouter()
+	r.HandleFunc("/account/{id}", func(w http.ResponseWriter, req *http.Request) {})
+	r.HandleFunc("/stats", tracker.StatsHandler)
+	r.Use(tracker.Middleware)
+
+	for _, path := range []string{"/account/1", "/account/2", "/account/3"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+
+	var stats map[string]RouteStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if got := stats["/account/{id}"].Count; got != 3 {
+		t.Errorf("/account/{id} count = %d, want 3 in %+v", got, stats)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Lifecycle collects cleanup hooks to run when the server shuts down
+type Lifecycle struct {
+	mu    sync.Mutex
+	hooks []func(context.Context) error
+}
+
+// The Lifecycle Run uses when ServerConfig.Lifecycle is nil
+var defaultLifecycle = &Lifecycle{}
+
+// OnShutdown registers fn with the default Lifecycle, for code that has no
+// ServerConfig at hand. See Lifecycle.OnShutdown.
+func OnShutdown(fn func(context.Context) error) {
+	defaultLifecycle.OnShutdown(fn)
+}
+
+// OnShutdown registers fn to run during shutdown. Hooks run in reverse
+// registration order, so resources are released before the ones they
+// depend on.
+func (l *Lifecycle) OnShutdown(fn func(context.Context) error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hooks = append(l.hooks, fn)
+}
+
+// Shutdown runs every hook in LIFO order and returns their combined errors.
+// A hook that panics is reported as an error and doesn't stop the rest.
+// Once ctx expires, a still-running hook is abandoned and the remaining
+// hooks are skipped.
+func (l *Lifecycle) Shutdown(ctx context.Context) error {
+	l.mu.Lock()
+	hooks := make([]func(context.Context) error, len(l.hooks))
+	copy(hooks, l.hooks)
+	l.mu.Unlock()
+
+	var errs multiError
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown hook %d skipped: %w", i, err))
+			continue
+		}
+		if err := runHook(ctx, hooks[i]); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown hook %d: %w", i, err))
+		}
+	}
+	return errs.errOrNil()
+}
+
+func runHook(ctx context.Context, fn func(context.Context) error) error {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- fn(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// multiError reports several errors as one
+type multiError []error
+
+func (m multiError) Error() string {
+	msgs := make([]string, len(m))
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is lets errors.Is match any of the aggregated errors
+func (m multiError) Is(target error) bool {
+	for _, err := range m {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m multiError) errOrNil() error {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
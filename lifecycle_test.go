@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLifecycleShutdown(t *testing.T) {
+	errHook := errors.New("flush failed")
+	tests := []struct {
+		name      string
+		hooks     []func(context.Context) error
+		timeout   time.Duration
+		wantOrder []int
+		wantErr   []string
+	}{
+		{
+			name:      "reverse order",
+			hooks:     []func(context.Context) error{nil, nil, nil},
+			timeout:   time.Second,
+			wantOrder: []int{2, 1, 0},
+		},
+		{
+			name:      "errors aggregated",
+			hooks:     []func(context.Context) error{func(context.Context) error { return errHook }, nil},
+			timeout:   time.Second,
+			wantOrder: []int{1, 0},
+			wantErr:   []string{"shutdown hook 0: flush failed"},
+		},
+		{
+			name:      "panic doesn't stop the rest",
+			hooks:     []func(context.Context) error{nil, func(context.Context) error { panic("boom") }},
+			timeout:   time.Second,
+			wantOrder: []int{1, 0},
+			wantErr:   []string{"shutdown hook 1: panic: boom"},
+		},
+		{
+			name: "deadline skips remaining hooks",
+			hooks: []func(context.Context) error{nil, func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			}},
+			timeout:   10 * time.Millisecond,
+			wantOrder: []int{1},
+			wantErr:   []string{"shutdown hook 1: context deadline exceeded", "shutdown hook 0 skipped"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lc := &Lifecycle{}
+			var (
+				mu    sync.Mutex
+				order []int
+			)
+			for i, hook := range tt.hooks {
+				i, hook := i, hook
+				lc.OnShutdown(func(ctx context.Context) error {
+					mu.Lock()
+					order = append(order, i)
+					mu.Unlock()
+					if hook == nil {
+						return nil
+					}
+					return hook(ctx)
+				})
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			err := lc.Shutdown(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+
+			if !reflect.DeepEqual(order, tt.wantOrder) {
+				t.Errorf("hooks ran in order %v, want %v", order, tt.wantOrder)
+			}
+			if len(tt.wantErr) == 0 && err != nil {
+				t.Errorf("Shutdown: %v", err)
+			}
+			for _, want := range tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("error %v does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunShutdownHooks(t *testing.T) {
+	errInit := errors.New("db unreachable")
+	tests := []struct {
+		name    string
+		init    func(context.Context) error
+		wantErr error
+	}{
+		{"graceful shutdown", nil, nil},
+		{"init fails", func(context.Context) error { return errInit }, errInit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ran := make(chan struct{}, 1)
+			cfg := DefaultServerConfig()
+			cfg.Addr = "127.0.0.1:0"
+			cfg.Init = tt.init
+			cfg.Lifecycle = &Lifecycle{}
+			cfg.Lifecycle.OnShutdown(func(context.Context) error {
+				ran <- struct{}{}
+				return nil
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			errc := make(chan error, 1)
+			go func() { errc <- Run(ctx, cfg, http.NotFoundHandler()) }()
+			if tt.init == nil {
+				time.Sleep(10 * time.Millisecond)
+				cancel()
+			}
+			defer cancel()
+
+			select {
+			case err := <-errc:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Run = %v, want %v", err, tt.wantErr)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Run did not return")
+			}
+			select {
+			case <-ran:
+			default:
+				t.Error("shutdown hook did not run")
+			}
+		})
+	}
+}
+
+func TestRunShutdownHookOrder(t *testing.T) {
+	errFlush := errors.New("flush failed")
+	tests := []struct {
+		name      string
+		hookErrs  []error
+		wantOrder []int
+		wantErr   error
+	}{
+		{"two hooks in reverse", []error{nil, nil}, []int{1, 0}, nil},
+		{"error returned by Run", []error{errFlush, nil}, []int{1, 0}, errFlush},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultServerConfig()
+			cfg.Addr = "127.0.0.1:0"
+			cfg.Lifecycle = &Lifecycle{}
+			var (
+				mu    sync.Mutex
+				order []int
+			)
+			for i, err := range tt.hookErrs {
+				i, err := i, err
+				cfg.Lifecycle.OnShutdown(func(ctx context.Context) error {
+					mu.Lock()
+					defer mu.Unlock()
+					order = append(order, i)
+					return err
+				})
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			errc := make(chan error, 1)
+			go func() { errc <- Run(ctx, cfg, http.NotFoundHandler()) }()
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+
+			var err error
+			select {
+			case err = <-errc:
+			case <-time.After(time.Second):
+				t.Fatal("Run did not return")
+			}
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil) != (err == nil) {
+				t.Errorf("Run = %v, want %v", err, tt.wantErr)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(order, tt.wantOrder) {
+				t.Errorf("hooks ran in order %v, want %v", order, tt.wantOrder)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Headers whose values never reach the logs unless overridden
+var defaultRedactedHeaders = []string{"Authorization", "Cookie", "X-API-Key"}
+
+// Severity of each access log level, least severe first
+var logLevels = map[string]int{"debug": 0, "info": 1, "warn": 2, "error": 3}
+
+// loggingConfig controls what the request logging middleware prints
+type loggingConfig struct {
+	redact        map[string]bool
+	slowThreshold time.Duration
+	sampleRate    int
+	contextFields []string
+	minLevel      int
+}
+
+// LoggingOption customizes LoggingFunc
+type LoggingOption func(*loggingConfig)
+
+// RedactHeaders masks the named headers in addition to the defaults
+// (Authorization, Cookie, X-API-Key). Only the value's length and a short
+// hash are logged, enough to correlate requests without exposing secrets.
+func RedactHeaders(names ...string) LoggingOption {
+	return func(c *loggingConfig) {
+		for _, n := range names {
+			c.redact[http.CanonicalHeaderKey(n)] = true
+		}
+	}
+}
+
+// SlowThreshold logs requests taking longer than d at WARN with slow=true.
+// Zero disables the check.
+func SlowThreshold(d time.Duration) LoggingOption {
+	return func(c *loggingConfig) {
+		c.slowThreshold = d
+	}
+}
+
+// SampleRate logs only about 1 in n successful (2xx/3xx) requests. Errors
+// and slow requests are always logged. Requests are kept or dropped by a
+// hash of their request id, the one RequestIDMiddleware stored or else the
+// caller's X-Request-ID, so every log line for a request agrees; without
+// one the choice is random. n <= 1 logs everything.
+func SampleRate(n int) LoggingOption {
+	return func(c *loggingConfig) {
+		c.sampleRate = n
+	}
+}
+
+// MinLevel drops access log lines less severe than level: "debug", "info",
+// "warn" or "error". Lines are INFO, or WARN when slow, so "warn" keeps only
+// slow requests and "error" silences the access log. Unknown levels panic,
+// as a configuration mistake.
+func MinLevel(level string) LoggingOption {
+	n, ok := logLevels[strings.ToLower(level)]
+	if !ok {
+		panic("MinLevel: unknown level " + level)
+	}
+	return func(c *loggingConfig) {
+		c.minLevel = n
+	}
+}
+
+// contextLogFields are the context values LogContext can add to the log
+// line, each returning false when the request has none
+var contextLogFields = map[string]func(context.Context) (string, bool){
+	"request_id": RequestIDFromContext,
+	"route": func(ctx context.Context) (string, bool) {
+		return RouteTemplate(ctx), true
+	},
+	"tenant": func(ctx context.Context) (string, bool) {
+		t, ok := TenantFromContext(ctx)
+		return t.ID, ok
+	},
+	"subject": func(ctx context.Context) (string, bool) {
+		p, ok := PrincipalFromContext(ctx)
+		if !ok {
+			return "", false
+		}
+		return p.ID, true
+	},
+}
+
+// LogContext adds the named context values to each log line: "request_id",
+// "route", "tenant" and "subject". Only values stored by middleware in front of the
+// logger are seen. A value the request doesn't carry is left out rather
+// than logged empty. Unknown names panic, as a configuration mistake.
+func LogContext(names ...string) LoggingOption {
+	for _, name := range names {
+		if _, ok := contextLogFields[name]; !ok {
+			panic("LogContext: unknown field " + name)
+		}
+	}
+	return func(c *loggingConfig) {
+		c.contextFields = append(c.contextFields, names...)
+	}
+}
+
+func newLoggingConfig(opts []LoggingOption) *loggingConfig {
+	c := &loggingConfig{redact: map[string]bool{}}
+	RedactHeaders(defaultRedactedHeaders...)(c)
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// logRequest writes the access log line for a finished request
+func (c *loggingConfig) logRequest(req *http.Request, status int, elapsed time.Duration) {
+	level, slow := "INFO", ""
+	if c.slowThreshold > 0 && elapsed > c.slowThreshold {
+		level, slow = "WARN", " slow=true"
+	}
+	if logLevels[strings.ToLower(level)] < c.minLevel {
+		return
+	}
+	if slow == "" && status < 400 && !c.sampled(req) {
+		return
+	}
+	fmt.Printf("level=%s %s status=%d duration=%s%s\n", level, c.formatRequest(req), status, elapsed, slow)
+}
+
+// sampled reports whether a successful request falls in the logged sample
+func (c *loggingConfig) sampled(req *http.Request) bool {
+	if c.sampleRate <= 1 {
+		return true
+	}
+	if id := requestID(req); id != "" {
+		h := fnv.New32a()
+		h.Write([]byte(id))
+		return h.Sum32()%uint32(c.sampleRate) == 0
+	}
+	return rand.Intn(c.sampleRate) == 0
+}
+
+// formatRequest renders the request fields worth logging
+func (c *loggingConfig) formatRequest(req *http.Request) string {
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "method=%s path=%s remote=%s", req.Method, req.URL.Path, req.RemoteAddr)
+	for _, name := range c.contextFields {
+		if v, ok := contextLogFields[name](req.Context()); ok && v != "" {
+			fmt.Fprintf(&b, " %s=%q", name, v)
+		}
+	}
+	for _, name := range names {
+		for _, v := range req.Header[name] {
+			if c.redact[name] {
+				v = redactValue(v)
+			}
+			fmt.Fprintf(&b, " %s=%q", name, v)
+		}
+	}
+	return b.String()
+}
+
+// redactValue replaces a secret with its length and a short hash
+func redactValue(v string) string {
+	sum := sha256.Sum256([]byte(v))
+	return fmt.Sprintf("[redacted len=%d sha256=%s]", len(v), hex.EncodeToString(sum[:4]))
+}
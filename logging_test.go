@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"hash/fnv"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sampledID reports whether id falls in a 1-in-n sample
+func sampledID(id string, n int) bool {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return h.Sum32()%uint32(n) == 0
+}
+
+// idsBySample returns one id that is sampled at rate n and one that isn't
+func idsBySample(n int) (in, out string) {
+	for i := 0; in == "" || out == ""; i++ {
+		id := "req-" + strconv.Itoa(i)
+		if sampledID(id, n) {
+			in = id
+		} else {
+			out = id
+		}
+	}
+	return in, out
+}
+
+func TestSampleRate(t *testing.T) {
+	const n = 4
+	in, out := idsBySample(n)
+	tests := []struct {
+		name      string
+		rate      int
+		contextID string
+		headerID  string
+		want      bool
+	}{
+		{name: "rate 1 logs everything", rate: 1, contextID: out, want: true},
+		{name: "context id sampled", rate: n, contextID: in, want: true},
+		{name: "context id dropped", rate: n, contextID: out, want: false},
+		{name: "context id wins over header", rate: n, contextID: out, headerID: in, want: false},
+		{name: "header id when context has none", rate: n, headerID: in, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newLoggingConfig([]LoggingOption{SampleRate(tt.rate)})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.headerID != "" {
+				req.Header.Set("X-Request-ID", tt.headerID)
+			}
+			if tt.contextID != "" {
+				req = req.WithContext(WithRequestID(req.Context(), tt.contextID))
+			}
+			for i := 0; i < 3; i++ {
+				if got := cfg.sampled(req); got != tt.want {
+					t.Fatalf("sampled = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSampleRateKeepsErrorsAndSlowRequests(t *testing.T) {
+	const n = 4
+	_, out := idsBySample(n)
+	tests := []struct {
+		name    string
+		status  int
+		elapsed time.Duration
+		want    bool
+	}{
+		{"dropped success", http.StatusOK, 0, false},
+		{"error", http.StatusInternalServerError, 0, true},
+		{"slow", http.StatusOK, time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newLoggingConfig([]LoggingOption{SampleRate(n), SlowThreshold(500 * time.Millisecond)})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req = req.WithContext(WithRequestID(req.Context(), out))
+			got := captureStdout(t, func() { cfg.logRequest(req, tt.status, tt.elapsed) })
+			if logged := got != ""; logged != tt.want {
+				t.Errorf("logged = %v, want %v: %q", logged, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMinLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		level   string
+		elapsed time.Duration
+		want    bool
+	}{
+		{"info keeps info", "info", 0, true},
+		{"debug keeps info", "DEBUG", 0, true},
+		{"warn drops info", "warn", 0, false},
+		{"warn keeps slow", "warn", time.Second, true},
+		{"error drops slow", "error", time.Second, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newLoggingConfig([]LoggingOption{MinLevel(tt.level), SlowThreshold(500 * time.Millisecond)})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			got := captureStdout(t, func() { cfg.logRequest(req, http.StatusOK, tt.elapsed) })
+			if logged := got != ""; logged != tt.want {
+				t.Errorf("logged = %v, want %v: %q", logged, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	const secret = "s3cr3t-token-value"
+	tests := []struct {
+		name   string
+		header string
+		opts   []LoggingOption
+		want   bool // whether the raw value may appear in the log
+	}{
+		{"authorization", "Authorization", nil, false},
+		{"cookie", "Cookie", nil, false},
+		{"api key", "X-API-Key", nil, false},
+		{"custom redacted", "X-Session", []LoggingOption{RedactHeaders("x-session")}, false},
+		{"not redacted", "X-Session", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := LoggingFunc(tt.opts...)(func(w http.ResponseWriter, req *http.Request) {})
+			req := httptest.NewRequest(http.MethodGet, "/account/1", nil)
+			req.Header.Set(tt.header, secret)
+			got := captureStdout(t, func() { h(httptest.NewRecorder(), req) })
+
+			if !strings.Contains(got, http.CanonicalHeaderKey(tt.header)+"=") {
+				t.Fatalf("header %s not logged: %q", tt.header, got)
+			}
+			if leaked := strings.Contains(got, secret); leaked != tt.want {
+				t.Errorf("raw value logged = %v, want %v: %q", leaked, tt.want, got)
+			}
+			if !tt.want && !strings.Contains(got, redactValue(secret)) {
+				t.Errorf("redacted value missing: %q", got)
+			}
+		})
+	}
+}
+
+func TestSlowThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold time.Duration
+		delay     time.Duration
+		wantLevel string
+		wantSlow  bool
+	}{
+		{"fast", 50 * time.Millisecond, 0, "level=INFO", false},
+		{"slow", 10 * time.Millisecond, 30 * time.Millisecond, "level=WARN", true},
+		{"disabled", 0, 30 * time.Millisecond, "level=INFO", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := LoggingFunc(SlowThreshold(tt.threshold))(func(w http.ResponseWriter, req *http.Request) {
+				time.Sleep(tt.delay)
+			})
+			got := captureStdout(t, func() { h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil)) })
+
+			if !strings.Contains(got, tt.wantLevel+" method=") {
+				t.Errorf("log = %q, want level %s", got, tt.wantLevel)
+			}
+			if slow := strings.Contains(got, "slow=true"); slow != tt.wantSlow {
+				t.Errorf("slow = %v, want %v: %q", slow, tt.wantSlow, got)
+			}
+		})
+	}
+}
+
+func TestLoggingFuncRecovers(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantStatus int
+		wantBody   string
+		wantLog    string
+	}{
+		{"panic before writing", func(w http.ResponseWriter, req *http.Request) {
+			panic(errors.New("boom"))
+		}, http.StatusInternalServerError, `{"error":"internal error"}`, "status=500"},
+		{"panic with a non-error value", func(w http.ResponseWriter, req *http.Request) {
+			panic("boom")
+		}, http.StatusInternalServerError, `{"error":"internal error"}`, "status=500"},
+		{"panic after writing", func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("partial"))
+			panic("boom")
+		}, http.StatusOK, "partial", "panic after response started: boom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			out := captureStdout(t, func() {
+				LoggingFunc()(tt.handler)(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			})
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.wantBody {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+			if !strings.Contains(out, tt.wantLog) {
+				t.Errorf("log %q does not contain %q", out, tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestLogContext(t *testing.T) {
+	tests := []struct {
+		name      string
+		fields    []string
+		requestID string // sent as X-Request-ID, through RequestIDMiddleware
+		tenant    string
+		subject   string
+		want      []string
+		wantNot   []string
+	}{
+		{"request id from the chain", []string{"request_id"}, "req-42", "", "", []string{`request_id="req-42"`}, nil},
+		{"generated request id", []string{"request_id"}, "", "", "", []string{`request_id="`}, nil},
+		{"all fields", []string{"request_id", "tenant", "subject"}, "req-42", "acme", "7",
+			[]string{`request_id="req-42"`, `tenant="acme"`, `subject="7"`}, nil},
+		{"missing values omitted", []string{"tenant", "subject"}, "req-42", "", "", nil, []string{"tenant=", "subject="}},
+		{"not selected", nil, "req-42", "acme", "7", nil, []string{"request_id=", "tenant=", "subject="}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logged := LoggingFunc(LogContext(tt.fields...))(func(w http.ResponseWriter, req *http.Request) {})
+			h := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				ctx := req.Context()
+				if tt.tenant != "" {
+					ctx = WithTenant(ctx, Tenant{ID: tt.tenant})
+				}
+				if tt.subject != "" {
+					ctx = WithPrincipal(ctx, &Principal{ID: tt.subject})
+				}
+				logged(w, req.WithContext(ctx))
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/account/1", nil)
+			if tt.requestID != "" {
+				req.Header.Set("X-Request-ID", tt.requestID)
+			}
+			got := captureStdout(t, func() { h.ServeHTTP(httptest.NewRecorder(), req) })
+
+			for _, s := range tt.want {
+				if !strings.Contains(got, s) {
+					t.Errorf("log missing %s: %q", s, got)
+				}
+			}
+			for _, s := range tt.wantNot {
+				if strings.Contains(got, s) {
+					t.Errorf("log has %s: %q", s, got)
+				}
+			}
+		})
+	}
+}
+
+func TestLogContextRejectsUnknownField(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("LogContext accepted an unknown field")
+		}
+	}()
+	LogContext("request_id", "nope")
+}
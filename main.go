@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"io"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Validate the ownership of the ID
@@ -47,11 +52,10 @@ func AuthorizationMiddleware_Bad(next http.Handler) http.Handler {
 }
 
 func GetAccount(rw http.ResponseWriter, req *http.Request) {
-	io.WriteString(rw, `{"message": "hello world.."}`)
+	RespondJSON(rw, req, http.StatusOK, map[string]string{"message": "hello world.."})
 }
 
-func main_bad() {
-	fmt.Println("running...")
+func buildBadHandler() http.Handler {
 	router := mux.NewRouter()
 	/* signature expected by Handle:
 	Handle(path string, handler http.Handler) *Route, so
@@ -63,8 +67,14 @@ func main_bad() {
 
 		router.Handle("/account/{id}", GetAccount)
 	*/
+	return router
+}
+
+func main_bad() error {
+	fmt.Println("running...")
+	router := buildBadHandler()
 	http.Handle("/", router)
-	http.ListenAndServe(":8000", router)
+	return RunServer(":8000", router)
 }
 
 /*
@@ -79,16 +89,20 @@ func main_bad() {
 	If we treat AuthorizationMiddleware (the concept, not the particular function) as sanitizer, the ok flow won't show.
 
 */
-func main_good() {
-	fmt.Println("running...")
+func buildGoodHandler() http.Handler {
 	router := mux.NewRouter()
 	router.Handle("/account/{id}", AuthorizationMiddleware(http.HandlerFunc(GetAccount)))
-	http.Handle("/", router)
-	http.ListenAndServe(":8000", router)
+	return router
 }
 
-func main_bad2() {
+func main_good() error {
 	fmt.Println("running...")
+	router := buildGoodHandler()
+	http.Handle("/", router)
+	return RunServer(":8000", router)
+}
+
+func buildBad2Handler() http.Handler {
 	router := mux.NewRouter()
 	/* signature expected by Handle:
 	Handle(path string, handler http.Handler) *Route, so
@@ -100,8 +114,14 @@ func main_bad2() {
 
 		router.Handle("/account/{id}", GetAccount)
 	*/
+	return router
+}
+
+func main_bad2() error {
+	fmt.Println("running...")
+	router := buildBad2Handler()
 	http.Handle("/", router)
-	http.ListenAndServe(":8000", router)
+	return RunServer(":8000", router)
 }
 
 ///
@@ -111,19 +131,31 @@ func main_bad2() {
 // A Middleware is a type of http.HandlerFunc
 type Middleware func(http.HandlerFunc) http.HandlerFunc
 
-func LoggingFunc() Middleware {
+func LoggingFunc(opts ...LoggingOption) Middleware {
+	cfg := newLoggingConfig(opts)
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, req *http.Request) {
+			if abandoned(req) {
+				return
+			}
 			// Logging middleware
-			fmt.Println(req)
+			start := time.Now()
+			rw := wrapResponseWriter(w)
 			defer func() {
-				if _, ok := recover().(error); ok {
-					w.WriteHeader(http.StatusInternalServerError)
+				if err := recover(); err != nil {
+					// once the status is on the wire a 500 can't replace it;
+					// writing one would only corrupt the body already sent
+					if rw.Committed() {
+						fmt.Println("panic after response started:", err)
+					} else {
+						respondJSON(rw, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+					}
 				}
+				cfg.logRequest(req, rw.Status(), time.Since(start))
 			}()
 
 			// Call next middleware/handler in chain
-			next(w, req)
+			next(rw, req)
 		}
 	}
 }
@@ -161,16 +193,19 @@ func Chain(f http.HandlerFunc, middlewares ...Middleware) http.HandlerFunc {
 	return f
 }
 
-// Create a server that uses a "chain" of middlware handlers
-func main_chain() {
-	r := mux.NewRouter()
+func buildChainHandler() http.Handler {
+	r := NewRouter()
 
 	// execute middleware from right to left of the chain
 	chain := Chain(SayHello, AuthFunc(), LoggingFunc())
 	r.HandleFunc("/account/{id}", chain)
+	return r
+}
 
+// Create a server that uses a "chain" of middlware handlers
+func main_chain() error {
 	fmt.Println("server listening: 8000")
-	http.ListenAndServe(":8000", r)
+	return RunServer(":8000", buildChainHandler())
 }
 
 ///
@@ -207,19 +242,67 @@ func MWSayHello(r *mux.Router) mux.MiddlewareFunc {
 	}
 }
 
-// Create a server that with a middleware chain via mux.Use()
-func main_uses_chain() {
-	r := mux.NewRouter()
+// buildUsesChainHandler builds the service's router. /whoami accepts access
+// tokens signed by issuer; nil leaves it on plain account ids.
+func buildUsesChainHandler(issuer *TokenIssuer) http.Handler {
+	r := NewRouter()
 
+	latency := NewLatencyTracker()
 	r.HandleFunc("/account/{id}", SayHello).Methods(http.MethodGet)
-	r.Use(MWAuthFunc(r))
+	r.Handle("/whoami", With(http.HandlerFunc(WhoAmI), AuthenticateMiddleware(AuthConfig{Tokens: issuer}))).Methods(http.MethodGet)
+	r.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+	r.HandleFunc("/stats", latency.StatsHandler).Methods(http.MethodGet)
+	r.Use(latency.Middleware)
+	r.Use(HeaderLimitMiddleware(100, 32<<10))
+	r.Use(RejectDuplicateHeaders())
+	r.Use(SkipMiddleware(MWAuthFunc(r), "/metrics", "/stats", "/whoami"))
+	return r
+}
+
+// Create a server that with a middleware chain via mux.Use()
+func main_uses_chain() error {
+	cfg, err := ConfigFromEnv()
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var issuer *TokenIssuer
+	if len(cfg.AuthSecret) > 0 {
+		issuer = &TokenIssuer{Secret: cfg.AuthSecret}
+	}
+	handler := ToMux(LoggingFunc(MinLevel(cfg.LogLevel)))(buildUsesChainHandler(issuer))
 
+	fmt.Println("server listening: " + cfg.Addr)
+	return Run(ctx, cfg, handler)
+}
+
+// Healthz reports that the process is up
+func Healthz(w http.ResponseWriter, req *http.Request) {
+	respondJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func buildPerRouteHandler() http.Handler {
+	r := NewRouter()
+
+	r.HandleFunc("/healthz", Healthz).Methods(http.MethodGet)
+	r.Handle("/account/{id}", With(http.HandlerFunc(SayHello), MWAuthFunc(r))).Methods(http.MethodGet)
+	return r
+}
+
+// Create a server where only the account route requires auth
+func main_per_route() error {
 	fmt.Println("server listening: 8000")
-	http.ListenAndServe(":8000", r)
+	return RunServer(":8000", buildPerRouteHandler())
 }
 
 ///
 // Actual main: call the appropriate sub-main
 func main() {
-	main_uses_chain()
+	if err := main_uses_chain(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBuildHandlers(t *testing.T) {
+	issuer := &TokenIssuer{Secret: []byte("k"), TTL: time.Minute}
+	tok, err := issuer.Issue("123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	usesChain := func() http.Handler { return buildUsesChainHandler(issuer) }
+	tests := []struct {
+		name  string
+		build func() http.Handler
+		path  string
+		auth  string
+		want  int
+	}{
+		{"good matching owner", buildGoodHandler, "/account/123", "123", http.StatusOK},
+		{"good mismatched owner", buildGoodHandler, "/account/123", "999", http.StatusUnauthorized},
+		{"good missing token", buildGoodHandler, "/account/123", "", http.StatusUnauthorized},
+		{"bad mismatched owner", buildBadHandler, "/account/123", "999", http.StatusOK},
+		{"bad missing token", buildBadHandler, "/account/123", "", http.StatusOK},
+		{"bad2 mismatched owner", buildBad2Handler, "/account/123", "999", http.StatusOK},
+		{"bad2 missing token", buildBad2Handler, "/account/123", "", http.StatusUnauthorized},
+		{"chain matching owner", buildChainHandler, "/account/123", "123", http.StatusOK},
+		{"chain mismatched owner", buildChainHandler, "/account/123", "999", http.StatusUnauthorized},
+		{"uses chain mismatched owner", usesChain, "/account/123", "999", http.StatusUnauthorized},
+		{"uses chain skips metrics", usesChain, "/stats", "", http.StatusOK},
+		{"uses chain whoami token", usesChain, "/whoami", "Bearer " + tok, http.StatusOK},
+		{"uses chain whoami forged token", usesChain, "/whoami", "Bearer junk", http.StatusUnauthorized},
+		{"per route mismatched owner", buildPerRouteHandler, "/account/123", "999", http.StatusUnauthorized},
+		{"per route public health", buildPerRouteHandler, "/healthz", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			captureStdout(t, func() { tt.build().ServeHTTP(rec, req) })
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Paths MaintenanceMiddleware always lets through: health checks, so the
+// orchestrator doesn't restart instances on purpose, and the admin routes,
+// so maintenance can be switched off again
+var maintenanceExempt = []string{"/health", "/healthz", "/admin"}
+
+// AtomicBool is a bool safe for concurrent use. It mirrors sync/atomic.Bool,
+// which needs a newer Go than this module targets.
+type AtomicBool struct {
+	v int32
+}
+
+// Load reports the current value
+func (b *AtomicBool) Load() bool {
+	return atomic.LoadInt32(&b.v) == 1
+}
+
+// Store sets the value to val
+func (b *AtomicBool) Store(val bool) {
+	var n int32
+	if val {
+		n = 1
+	}
+	atomic.StoreInt32(&b.v, n)
+}
+
+// MaintenanceMiddleware answers 503 with Retry-After while flag is set,
+// letting only health checks and admin routes through. Flipping flag takes
+// effect on the next request; nothing needs redeploying.
+func MaintenanceMiddleware(flag *AtomicBool, retryAfter time.Duration) mux.MiddlewareFunc {
+	secs := strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if flag.Load() && !pathUnder(req.URL.Path, maintenanceExempt) {
+				w.Header().Set("Retry-After", secs)
+				respondJSON(w, http.StatusServiceUnavailable, &APIError{
+					HTTPStatus: http.StatusServiceUnavailable,
+					ErrorCode:  "maintenance",
+					Message:    "service under maintenance",
+				})
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// Role required to call the /admin routes
+const adminRole = "admin"
+
+type maintenanceState struct {
+	Enabled bool `json:"enabled"`
+}
+
+// MaintenanceHandler switches maintenance mode on for POST and off for
+// DELETE, and answers every method with the resulting state
+func MaintenanceHandler(flag *AtomicBool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodPost:
+			flag.Store(true)
+		case http.MethodDelete:
+			flag.Store(false)
+		}
+		respondJSON(w, http.StatusOK, maintenanceState{Enabled: flag.Load()})
+	}
+}
+
+// AdminRoutes lists what RegisterAdminRoutes exposes; nil fields are left
+// unmounted
+type AdminRoutes struct {
+	// Maintenance is switched by GET, POST and DELETE /admin/maintenance
+	Maintenance *AtomicBool
+	// RateLimiter has its buckets listed by GET /admin/ratelimits
+	RateLimiter *RateLimiter
+	// Routes has its route table listed by GET /admin/routes
+	Routes *mux.Router
+}
+
+// RegisterAdminRoutes mounts the routes in admin under /admin on r, open
+// only to callers authenticated by cfg who hold the admin role. The auth
+// chain is checked with UseChain, whose error is returned.
+func RegisterAdminRoutes(r *mux.Router, cfg AuthConfig, admin AdminRoutes) error {
+	sub := r.PathPrefix("/admin").Subrouter()
+	if err := UseChain(sub, DeclareAuthenticate(cfg), DeclareRequireRole(adminRole)); err != nil {
+		return fmt.Errorf("admin routes: %w", err)
+	}
+	if admin.Maintenance != nil {
+		sub.Handle("/maintenance", MaintenanceHandler(admin.Maintenance)).
+			Methods(http.MethodGet, http.MethodPost, http.MethodDelete)
+	}
+	if admin.RateLimiter != nil {
+		sub.HandleFunc("/ratelimits", admin.RateLimiter.SnapshotHandler).Methods(http.MethodGet)
+	}
+	if admin.Routes != nil {
+		sub.Handle("/routes", RoutesHandler(admin.Routes)).Methods(http.MethodGet)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-rest-api-example/testutil"
+)
+
+func TestMaintenanceMiddleware(t *testing.T) {
+	tests := []struct {
+		name      string
+		on        bool
+		path      string
+		want      int
+		wantRetry string
+	}{
+		{"on", true, "/account/1", http.StatusServiceUnavailable, "90"},
+		{"on health", true, "/healthz", http.StatusOK, ""},
+		{"on admin", true, "/admin/maintenance", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flag := &AtomicBool{}
+			flag.Store(tt.on)
+			rec, _ := testutil.InvokeMiddleware(MaintenanceMiddleware(flag, 90*time.Second), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			testutil.AssertStatus(t, rec, tt.want)
+			if got := rec.Header().Get("Retry-After"); got != tt.wantRetry {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetry)
+			}
+			if tt.want == http.StatusServiceUnavailable && !strings.Contains(rec.Body.String(), `"error":"maintenance"`) {
+				t.Errorf("body = %s", rec.Body)
+			}
+		})
+	}
+}
+
+func TestMaintenanceToggle(t *testing.T) {
+	issuer := &TokenIssuer{Secret: []byte("secret"), TTL: time.Hour}
+	flag := &AtomicBool{}
+	srv, _ := NewTestServer(t, WithAuth(AuthConfig{Tokens: issuer}), WithMaintenance(flag))
+	admin, err := issuer.Issue("ops", adminRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		method      string
+		wantEnabled bool
+		wantBlocked bool // whether account requests get the maintenance 503 afterwards
+	}{
+		{http.MethodPost, true, true},
+		{http.MethodGet, true, true},
+		{http.MethodDelete, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, srv.URL+"/admin/maintenance", nil)
+			req.Header.Set("Authorization", "Bearer "+admin)
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var state maintenanceState
+			json.NewDecoder(resp.Body).Decode(&state)
+			resp.Body.Close()
+			if state.Enabled != tt.wantEnabled || flag.Load() != tt.wantEnabled {
+				t.Errorf("enabled = %v (flag %v), want %v", state.Enabled, flag.Load(), tt.wantEnabled)
+			}
+
+			req, _ = http.NewRequest(http.MethodGet, srv.URL+"/account/1", nil)
+			req.Header.Set("Authorization", "Bearer "+admin)
+			resp, err = srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if blocked := resp.StatusCode == http.StatusServiceUnavailable; blocked != tt.wantBlocked {
+				t.Errorf("account status = %d, want blocked = %v", resp.StatusCode, tt.wantBlocked)
+			}
+		})
+	}
+}
+
+func TestMaintenanceKeepsAdminReachable(t *testing.T) {
+	issuer := &TokenIssuer{Secret: []byte("secret"), TTL: time.Hour}
+	flag := &AtomicBool{}
+	flag.Store(true)
+	srv, _ := NewTestServer(t, WithAuth(AuthConfig{Tokens: issuer}), WithMaintenance(flag))
+	admin, err := issuer.Issue("ops", adminRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, err := issuer.Issue("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, method, path, token string
+		want                      int
+	}{
+		{"admin routes", http.MethodGet, "/admin/routes", admin, http.StatusOK},
+		{"admin state", http.MethodGet, "/admin/maintenance", admin, http.StatusOK},
+		{"admin still checked", http.MethodGet, "/admin/maintenance", owner, http.StatusForbidden},
+		{"account owner", http.MethodGet, "/account/1", owner, http.StatusServiceUnavailable},
+		{"account admin", http.MethodGet, "/accounts", admin, http.StatusServiceUnavailable},
+		{"anonymous", http.MethodGet, "/accounts", "", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+			if tt.want == http.StatusServiceUnavailable && resp.Header.Get("Retry-After") == "" {
+				t.Error("503 without Retry-After")
+			}
+		})
+	}
+}
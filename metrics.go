@@ -0,0 +1,24 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Fraction of ConcurrencyLimitMiddleware's slots currently taken, 0 to 1
+var concurrencyUtilization = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "http_concurrency_utilization",
+	Help: "Fraction of concurrent request slots in use.",
+})
+
+// Requests being served right now, maintained by Run's in-flight tracking
+var inflightRequests = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "http_inflight_requests",
+	Help: "Number of HTTP requests currently being served.",
+})
+
+// Account lookups answered by joining one already in flight
+var coalescedLookups = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "account_lookups_coalesced_total",
+	Help: "Account lookups served by sharing a concurrent identical lookup.",
+})
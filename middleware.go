@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Body sent with the 503 when a request runs past its deadline
+const timeoutBody = `{"error": "request timed out"}`
+
+// TimeoutMiddleware bounds every request to d. The deadline is carried on the
+// request context; a handler that hasn't finished by then gets a 503.
+func TimeoutMiddleware(d time.Duration) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return timeoutHandler(next, d)
+	}
+}
+
+// timeoutHandler is http.TimeoutHandler answering with timeoutBody. The
+// writer TimeoutHandler gives next hides any ErrorFormatMiddleware further
+// out, so the format is passed across.
+func timeoutHandler(next http.Handler, d time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		inner := ErrorFormatMiddleware(errorFormat(w))(next)
+		http.TimeoutHandler(inner, d, timeoutBody).ServeHTTP(w, req)
+	})
+}
+
+// GlobalTimeoutMiddleware bounds every request to d like TimeoutMiddleware,
+// except those whose path matches one of exclude. http.TimeoutHandler
+// buffers the whole response, so streaming routes such as
+// "/account/{id}/events" must be excluded. Patterns use mux-style {name}
+// placeholders, each matching a single path segment. The 503 is labelled
+// as JSON up front, so a handler that doesn't set its own Content-Type is
+// served as JSON too. d <= 0 disables the timeout.
+func GlobalTimeoutMiddleware(d time.Duration, exclude ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+		timed := timeoutHandler(next, d)
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			for _, pattern := range exclude {
+				if matchPathPattern(pattern, req.URL.Path) {
+					next.ServeHTTP(w, req)
+					return
+				}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			timed.ServeHTTP(w, req)
+		})
+	}
+}
+
+// matchPathPattern reports whether path matches pattern segment by segment,
+// with "{name}" segments matching anything
+func matchPathPattern(pattern, path string) bool {
+	ps := strings.Split(strings.Trim(pattern, "/"), "/")
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+	if len(ps) != len(segs) {
+		return false
+	}
+	for i, p := range ps {
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+			if segs[i] == "" {
+				return false
+			}
+			continue
+		}
+		if p != segs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// DeadlineHeaderMiddleware lets the client pick its own deadline via
+// "X-Request-Timeout: 500ms", clamped to max. A missing or unparseable
+// header falls back to def rather than failing the request. A def of zero
+// means no default deadline, and a max of zero means no cap.
+func DeadlineHeaderMiddleware(def, max time.Duration) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			d := def
+			if v := req.Header.Get("X-Request-Timeout"); v != "" {
+				if parsed, err := time.ParseDuration(v); err == nil && parsed > 0 {
+					d = parsed
+				}
+			}
+			if max > 0 && d > max {
+				d = max
+			}
+			if d <= 0 {
+				next.ServeHTTP(w, req)
+				return
+			}
+			timeoutHandler(next, d).ServeHTTP(w, req)
+		})
+	}
+}
+
+// DebugBodyLoggingMiddleware is BodyCaptureMiddleware with the default
+// redacted fields
+func DebugBodyLoggingMiddleware(enabled bool, maxBytes int) mux.MiddlewareFunc {
+	return BodyCaptureMiddleware(BodyCaptureConfig{Enabled: enabled, MaxBytes: maxBytes})
+}
+
+// RequireContentType rejects requests carrying a body whose media type isn't
+// expected with a 415. Parameters such as "; charset=utf-8" are ignored.
+// Requests without a body pass through.
+func RequireContentType(expected string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if hasBody(req) {
+				mt, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+				if err != nil || !strings.EqualFold(mt, expected) {
+					respondJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "content type must be " + expected})
+					return
+				}
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// RequireContentLength answers 411 to a POST or PUT whose body has no
+// Content-Length, i.e. one sent with chunked transfer encoding. Bodiless
+// requests and other methods pass through. It is opt-in, for backends
+// that must know a body's size up front.
+func RequireContentLength(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if (req.Method == http.MethodPost || req.Method == http.MethodPut) && hasBody(req) && req.ContentLength < 0 {
+			respondError(w, req, http.StatusLengthRequired, "Content-Length required")
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// abandoned reports whether the client has already gone away (or the
+// request's deadline passed), in which case middleware can stop without
+// doing further work or writing a response nobody will read
+func abandoned(req *http.Request) bool {
+	return req.Context().Err() != nil
+}
+
+// hasBody reports whether req carries a request body. A ContentLength of -1
+// means the length is unknown (e.g. chunked), which still counts.
+func hasBody(req *http.Request) bool {
+	return req.Body != nil && req.Body != http.NoBody && req.ContentLength != 0
+}
+
+// HostWhitelistMiddleware rejects requests whose Host isn't in allowed with
+// a 421. Entries may be exact hosts or "*.example.com", which matches any
+// subdomain but not example.com itself. Ports and case are ignored. An
+// empty list allows every host.
+func HostWhitelistMiddleware(allowed []string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if len(allowed) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if !hostAllowed(canonicalHost(req.Host), allowed) {
+				respondJSON(w, http.StatusMisdirectedRequest, map[string]string{"error": "unknown host"})
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// canonicalHost lowercases host and strips any port and trailing dot
+func canonicalHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+func hostAllowed(host string, allowed []string) bool {
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if strings.HasPrefix(a, "*.") {
+			if strings.HasSuffix(host, a[1:]) && len(host) > len(a)-1 {
+				return true
+			}
+		} else if host == a {
+			return true
+		}
+	}
+	return false
+}
+
+// ConcurrencyLimitMiddleware serves at most max requests at once. Requests
+// arriving while every slot is taken wait up to queueTimeout for one to
+// free up, then get a 503 with Retry-After; zero means they are turned away
+// at once. A slot is released when the handler returns, even by panicking.
+// Slot usage is exported as the http_concurrency_utilization gauge.
+// max <= 0 means no limit.
+func ConcurrencyLimitMiddleware(max int, queueTimeout time.Duration) mux.MiddlewareFunc {
+	if max <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	sem := make(chan struct{}, max)
+	report := func() { concurrencyUtilization.Set(float64(len(sem)) / float64(max)) }
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if !acquire(req, sem, queueTimeout) {
+				if abandoned(req) {
+					return
+				}
+				w.Header().Set("Retry-After", "1")
+				respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "server busy"})
+				return
+			}
+			report()
+			defer func() {
+				<-sem
+				report()
+			}()
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// acquire takes a slot in sem, waiting up to timeout for one unless req is
+// abandoned first
+func acquire(req *http.Request, sem chan struct{}, timeout time.Duration) bool {
+	select {
+	case sem <- struct{}{}:
+		return true
+	default:
+	}
+	if timeout <= 0 {
+		return false
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case sem <- struct{}{}:
+		return true
+	case <-t.C:
+		return false
+	case <-req.Context().Done():
+		return false
+	}
+}
+
+// SkipMiddleware applies mw to every request except those whose path falls
+// under one of skipPrefixes, which go straight to the handler. Prefixes
+// match whole path segments: "/health" skips "/health" and "/health/live"
+// but not "/healthz".
+func SkipMiddleware(mw mux.MiddlewareFunc, skipPrefixes ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if pathUnder(req.URL.Path, skipPrefixes) {
+				next.ServeHTTP(w, req)
+				return
+			}
+			wrapped.ServeHTTP(w, req)
+		})
+	}
+}
+
+func pathUnder(path string, prefixes []string) bool {
+	for _, p := range prefixes {
+		p = strings.TrimSuffix(p, "/")
+		if path == p || strings.HasPrefix(path, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxURILengthMiddleware answers 414 when the request URI is longer than
+// max bytes. mux runs r.Use middleware only after matching a route, so to
+// spare the route matcher wrap the router with it directly. max <= 0
+// disables the check.
+func MaxURILengthMiddleware(max int) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if max <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if len(req.RequestURI) > max || len(req.URL.RequestURI()) > max {
+				respondJSON(w, http.StatusRequestURITooLong, map[string]string{"error": "request URI too long"})
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// How many rounds of percent-decoding PathTraversalMiddleware looks through
+const maxPathDecodes = 3
+
+// PathTraversalMiddleware answers 400 when the request path, raw or after
+// up to maxPathDecodes rounds of percent-decoding, has a "." or ".."
+// segment, catching "..%2f" and double-encoded "%252e%252e" alike. Dots
+// inside a segment, as in "a..b", are fine, and the query string is left
+// to the handlers. Like MaxURILengthMiddleware it belongs around the
+// router, so it runs before routing.
+func PathTraversalMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if hasTraversal(req.URL.EscapedPath()) {
+			fmt.Println("path traversal rejected:", req.URL.EscapedPath())
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid path"})
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// hasTraversal reports whether p, or any decoding of it, has a dot segment
+func hasTraversal(p string) bool {
+	for i := 0; ; i++ {
+		if hasDotSegment(p) {
+			return true
+		}
+		if i == maxPathDecodes {
+			return false
+		}
+		decoded, err := url.PathUnescape(p)
+		if err != nil || decoded == p {
+			return false
+		}
+		p = decoded
+	}
+}
+
+// hasDotSegment reports whether p has a "." or ".." segment, splitting on
+// backslashes too since some backends treat them as separators
+func hasDotSegment(p string) bool {
+	segments := strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == '\\' })
+	for _, seg := range segments {
+		if seg == "." || seg == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// TrailingSlashMode selects what TrailingSlashMiddleware does with a path
+// ending in "/"
+type TrailingSlashMode int
+
+const (
+	// TrailingSlashOff leaves paths alone, so "/account/123/" is a 404
+	TrailingSlashOff TrailingSlashMode = iota
+	// TrailingSlashRedirect sends the client to the path without the slash
+	TrailingSlashRedirect
+	// TrailingSlashStrip serves the path without the slash as if it had
+	// been requested
+	TrailingSlashStrip
+)
+
+// TrailingSlashMiddleware canonicalizes "/account/123/" to "/account/123"
+// according to mode. Redirects are 301 for GET and HEAD and 308 for
+// everything else, so a POST is not replayed as a GET; the query string is
+// kept. The root path is never changed. Like MaxURILengthMiddleware it must
+// wrap the router, since the slashed path matches no route.
+func TrailingSlashMiddleware(mode TrailingSlashMode) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if mode == TrailingSlashOff {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			path := req.URL.Path
+			// "//host/" would become a protocol-relative redirect elsewhere
+			if len(path) <= 1 || !strings.HasSuffix(path, "/") || strings.HasPrefix(path, "//") {
+				next.ServeHTTP(w, req)
+				return
+			}
+			u := *req.URL
+			u.Path = strings.TrimRight(path, "/")
+			u.RawPath = strings.TrimRight(u.RawPath, "/")
+			if u.Path == "" {
+				u.Path, u.RawPath = "/", ""
+			}
+			if mode == TrailingSlashStrip {
+				r2 := req.Clone(req.Context())
+				r2.URL = &u
+				next.ServeHTTP(w, r2)
+				return
+			}
+			code := http.StatusPermanentRedirect
+			if req.Method == http.MethodGet || req.Method == http.MethodHead {
+				code = http.StatusMovedPermanently
+			}
+			http.Redirect(w, req, u.RequestURI(), code)
+		})
+	}
+}
+
+// HeaderLimitMiddleware answers 431 when a request carries more than
+// maxCount header values or more than maxTotalBytes of headers, each value
+// counted as it appears on the wire ("Name: value\r\n"). It complements
+// ServerConfig.MaxHeaderBytes, which caps the raw header block, with a
+// tighter per-route limit. A limit <= 0 is not checked.
+func HeaderLimitMiddleware(maxCount, maxTotalBytes int) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			count, size := 0, 0
+			for name, values := range req.Header {
+				count += len(values)
+				for _, v := range values {
+					size += len(name) + len(v) + len(": \r\n")
+				}
+			}
+			if (maxCount > 0 && count > maxCount) || (maxTotalBytes > 0 && size > maxTotalBytes) {
+				respondError(w, req, http.StatusRequestHeaderFieldsTooLarge, "too many or too large headers")
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// Headers RejectDuplicateHeaders checks when given no names
+var singleValueHeaders = []string{"Authorization", "X-API-Key"}
+
+// RejectDuplicateHeaders answers 400 when a request carries more than one
+// value for any of names (Authorization and X-API-Key by default).
+// req.Header.Get only sees the first value, so a second one that some other
+// layer reads instead would make the request's identity ambiguous.
+func RejectDuplicateHeaders(names ...string) mux.MiddlewareFunc {
+	if len(names) == 0 {
+		names = singleValueHeaders
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if apiErr := duplicateHeader(req, names...); apiErr != nil {
+				writeError(w, apiErr)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// duplicateHeader returns the 400 for the first of names req carries more
+// than one value for, or nil
+func duplicateHeader(req *http.Request, names ...string) *APIError {
+	for _, name := range names {
+		if len(req.Header.Values(name)) > 1 {
+			fmt.Println("duplicate header:", name)
+			return &APIError{HTTPStatus: http.StatusBadRequest, ErrorCode: "bad_request", Message: "duplicate " + http.CanonicalHeaderKey(name) + " header"}
+		}
+	}
+	return nil
+}
+
+// UserAgentFilterMiddleware answers 403 to requests whose User-Agent
+// contains any of blocklist, compared case-insensitively, or, when
+// requireNonEmpty is set, that send no User-Agent at all
+func UserAgentFilterMiddleware(blocklist []string, requireNonEmpty bool) mux.MiddlewareFunc {
+	blocked := make([]string, 0, len(blocklist))
+	for _, b := range blocklist {
+		if b != "" {
+			blocked = append(blocked, strings.ToLower(b))
+		}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			ua := strings.TrimSpace(req.UserAgent())
+			if ua == "" && requireNonEmpty {
+				writeError(w, &APIError{HTTPStatus: http.StatusForbidden, ErrorCode: "forbidden", Message: "user agent required", Err: ErrForbidden})
+				return
+			}
+			lower := strings.ToLower(ua)
+			for _, b := range blocked {
+				if strings.Contains(lower, b) {
+					fmt.Println("blocked user agent:", ua)
+					writeError(w, &APIError{HTTPStatus: http.StatusForbidden, ErrorCode: "forbidden", Message: "user agent not allowed", Err: ErrForbidden})
+					return
+				}
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// GzipRequestMiddleware decompresses request bodies sent with
+// "Content-Encoding: gzip" so handlers read plain bytes. The body is
+// inflated up front, up to maxBytes, so a corrupt stream is answered with
+// a 400 before the handler runs and a zip bomb with a 413 rather than
+// exhausting memory. Other requests pass through.
+func GzipRequestMiddleware(maxBytes int64) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			enc := strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding")))
+			if (enc != "gzip" && enc != "x-gzip") || !hasBody(req) {
+				next.ServeHTTP(w, req)
+				return
+			}
+			gz, err := gzip.NewReader(req.Body)
+			if err != nil {
+				respondJSON(w, http.StatusBadRequest, map[string]string{"error": "malformed gzip body"})
+				return
+			}
+			body, err := io.ReadAll(io.LimitReader(gz, maxBytes+1))
+			gz.Close()
+			req.Body.Close()
+			switch {
+			case err != nil:
+				respondJSON(w, http.StatusBadRequest, map[string]string{"error": "malformed gzip body"})
+				return
+			case int64(len(body)) > maxBytes:
+				respondJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": "decompressed body too large"})
+				return
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			req.ContentLength = int64(len(body))
+			req.Header.Del("Content-Encoding")
+			next.ServeHTTP(w, req)
+		})
+	}
+}
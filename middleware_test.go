@@ -0,0 +1,630 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-rest-api-example/testutil"
+
+	"github.com/gorilla/mux"
+)
+
+func TestConcurrencyLimitMiddleware(t *testing.T) {
+	tests := []struct {
+		name         string
+		max          int
+		queueTimeout time.Duration
+		holdFor      time.Duration // how long the request holding a slot runs
+		want         int
+	}{
+		{"negative max is unlimited", -1, 0, 50 * time.Millisecond, http.StatusOK},
+		{"zero max is unlimited", 0, 0, 50 * time.Millisecond, http.StatusOK},
+		{"full without queue", 1, 0, 50 * time.Millisecond, http.StatusServiceUnavailable},
+		{"queued until a slot frees", 1, time.Second, 20 * time.Millisecond, http.StatusOK},
+		{"queue times out", 1, 10 * time.Millisecond, 200 * time.Millisecond, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{}, 2)
+			h := ConcurrencyLimitMiddleware(tt.max, tt.queueTimeout)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				started <- struct{}{}
+				if req.Header.Get("X-Hold") != "" {
+					time.Sleep(tt.holdFor)
+				}
+			}))
+
+			holding := httptest.NewRequest(http.MethodGet, "/", nil)
+			holding.Header.Set("X-Hold", "1")
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				h.ServeHTTP(httptest.NewRecorder(), holding)
+			}()
+			<-started
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			<-done
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") == "" {
+				t.Error("503 without Retry-After")
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimitReleasesOnPanic(t *testing.T) {
+	tests := []struct {
+		name   string
+		panics int // requests that panic before the one checked
+		want   int
+	}{
+		{"one panic", 1, http.StatusOK},
+		{"more panics than slots", 3, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := ConcurrencyLimitMiddleware(1, 0)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.Header.Get("X-Panic") != "" {
+					panic("boom")
+				}
+			}))
+			for i := 0; i < tt.panics; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.Header.Set("X-Panic", "1")
+				func() {
+					defer func() { recover() }()
+					h.ServeHTTP(httptest.NewRecorder(), req)
+				}()
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeadlineHeaderMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		def, max time.Duration
+		header   string
+		sleep    time.Duration
+		want     int
+	}{
+		{"no default and no header", 0, time.Second, "", 10 * time.Millisecond, http.StatusOK},
+		{"no cap", 0, 0, "1s", 10 * time.Millisecond, http.StatusOK},
+		{"header within deadline", 0, time.Second, "500ms", 10 * time.Millisecond, http.StatusOK},
+		{"header deadline exceeded", 0, time.Second, "5ms", 100 * time.Millisecond, http.StatusServiceUnavailable},
+		{"default deadline exceeded", 5 * time.Millisecond, time.Second, "", 100 * time.Millisecond, http.StatusServiceUnavailable},
+		{"unparseable header uses default", 5 * time.Millisecond, time.Second, "soon", 100 * time.Millisecond, http.StatusServiceUnavailable},
+		{"header clamped to max", 0, 5 * time.Millisecond, "10s", 100 * time.Millisecond, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		tt := tt // the timed-out handler outlives the subtest
+		t.Run(tt.name, func(t *testing.T) {
+			h := DeadlineHeaderMiddleware(tt.def, tt.max)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				select {
+				case <-time.After(tt.sleep):
+				case <-req.Context().Done():
+				}
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Request-Timeout", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestDebugBodyLoggingMiddleware(t *testing.T) {
+	body := `{"name":"` + strings.Repeat("a", 40) + `"}`
+	tests := []struct {
+		name     string
+		enabled  bool
+		maxBytes int
+		wantLog  []string
+	}{
+		{"disabled", false, 16, nil},
+		{"whole body", true, 1024, []string{"request_id=req-1", `request_body="{\"name\":\"aaaa`, "status=201", `response_body="created"`}},
+		{"truncated", true, 16, []string{`request_body="{\"name\":\"aaaaaaa...`, `response_body="created"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			h := DebugBodyLoggingMiddleware(tt.enabled, tt.maxBytes)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				b, _ := io.ReadAll(req.Body)
+				seen = string(b)
+				w.WriteHeader(http.StatusCreated)
+				io.WriteString(w, "created")
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/accounts", strings.NewReader(body))
+			req = req.WithContext(WithRequestID(req.Context(), "req-1"))
+			out := captureStdout(t, func() { h.ServeHTTP(httptest.NewRecorder(), req) })
+
+			if seen != body {
+				t.Errorf("handler read %q, want %q", seen, body)
+			}
+			if len(tt.wantLog) == 0 && out != "" {
+				t.Errorf("disabled middleware logged %q", out)
+			}
+			for _, want := range tt.wantLog {
+				if !strings.Contains(out, want) {
+					t.Errorf("log %q does not contain %q", out, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRejectDuplicateHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		mw      mux.MiddlewareFunc
+		headers map[string][]string
+		want    int
+	}{
+		{"duplicate authorization", RejectDuplicateHeaders(), map[string][]string{"Authorization": {"1", "2"}}, http.StatusBadRequest},
+		{"duplicate api key", RejectDuplicateHeaders(), map[string][]string{"X-Api-Key": {"a", "b"}}, http.StatusBadRequest},
+		{"unchecked header", RejectDuplicateHeaders(), map[string][]string{"Accept": {"a", "b"}}, http.StatusOK},
+		{"named header", RejectDuplicateHeaders("Accept"), map[string][]string{"Accept": {"a", "b"}, "Authorization": {"1", "2"}}, http.StatusBadRequest},
+		{"auth config", AuthenticateMiddleware(AuthConfig{RejectDuplicateHeaders: true}), map[string][]string{"Authorization": {"1", "2"}}, http.StatusBadRequest},
+		{"auth config off", AuthenticateMiddleware(AuthConfig{}), map[string][]string{"Authorization": {"1", "2"}}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for name, values := range tt.headers {
+				for _, v := range values {
+					req.Header.Add(name, v)
+				}
+			}
+			rec, next := testutil.InvokeMiddleware(tt.mw, req)
+			testutil.AssertStatus(t, rec, tt.want)
+			if called := next != nil; called != (tt.want == http.StatusOK) {
+				t.Errorf("next called = %v", called)
+			}
+			if tt.want == http.StatusBadRequest && !strings.Contains(rec.Body.String(), "duplicate") {
+				t.Errorf("body %s does not name the duplicate", rec.Body)
+			}
+		})
+	}
+}
+
+func TestRequireContentType(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		body        string
+		contentType string
+		want        int
+	}{
+		{"matching with charset", http.MethodPut, `{}`, "application/json; charset=utf-8", http.StatusOK},
+		{"matching case-insensitively", http.MethodPost, `{}`, "Application/JSON", http.StatusOK},
+		{"mismatching", http.MethodPost, `a=1`, "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"missing", http.MethodPost, `{}`, "", http.StatusUnsupportedMediaType},
+		{"malformed", http.MethodPost, `{}`, "application/json; =", http.StatusUnsupportedMediaType},
+		{"bodiless GET", http.MethodGet, "", "", http.StatusOK},
+		{"bodiless DELETE", http.MethodDelete, "", "text/plain", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/account/1", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec, _ := testutil.InvokeMiddleware(RequireContentType("application/json"), req)
+			testutil.AssertStatus(t, rec, tt.want)
+			if tt.want == http.StatusUnsupportedMediaType {
+				testutil.AssertJSONError(t, rec, tt.want, "content type must be application/json")
+			}
+		})
+	}
+}
+
+func TestHostWhitelistMiddleware(t *testing.T) {
+	allowed := []string{"api.example.org", "*.example.com"}
+	tests := []struct {
+		name    string
+		allowed []string
+		host    string
+		want    int
+	}{
+		{"allowed host with port and case", allowed, "API.example.org:8080", http.StatusOK},
+		{"disallowed host", allowed, "evil.org", http.StatusMisdirectedRequest},
+		{"wildcard match", allowed, "tenant.example.com", http.StatusOK},
+		{"wildcard nested", allowed, "a.b.example.com", http.StatusOK},
+		{"wildcard excludes apex", allowed, "example.com", http.StatusMisdirectedRequest},
+		{"wildcard suffix only", allowed, "badexample.com", http.StatusMisdirectedRequest},
+		{"empty list allows all", nil, "evil.org", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = tt.host
+			rec, next := testutil.InvokeMiddleware(HostWhitelistMiddleware(tt.allowed), req)
+			testutil.AssertStatus(t, rec, tt.want)
+			if called := next != nil; called != (tt.want == http.StatusOK) {
+				t.Errorf("next called = %v", called)
+			}
+		})
+	}
+}
+
+func TestSkipMiddleware(t *testing.T) {
+	mw := SkipMiddleware(AuthenticateMiddleware(AuthConfig{}), "/health", "/docs/")
+	tests := []struct {
+		name string
+		path string
+		auth string
+		want int
+	}{
+		{"skipped prefix", "/health", "", http.StatusOK},
+		{"under skipped prefix", "/health/live", "", http.StatusOK},
+		{"trailing slash prefix", "/docs/openapi.json", "", http.StatusOK},
+		{"partial segment not skipped", "/healthz", "", http.StatusUnauthorized},
+		{"other path unauthenticated", "/account/1", "", http.StatusUnauthorized},
+		{"other path authenticated", "/account/1", "1", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec, _ := testutil.InvokeMiddleware(mw, req)
+			testutil.AssertStatus(t, rec, tt.want)
+		})
+	}
+}
+
+func TestMaxURILengthMiddleware(t *testing.T) {
+	tests := []struct {
+		name string
+		max  int
+		uri  string
+		want int
+	}{
+		{"at limit", 10, "/account/1", http.StatusOK},
+		{"over limit", 9, "/account/1", http.StatusRequestURITooLong},
+		{"query counts", 16, "/account/1?q=" + strings.Repeat("x", 8), http.StatusRequestURITooLong},
+		{"disabled", 0, "/" + strings.Repeat("x", 4096), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mux.NewRouter()
+			r.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+			rec := httptest.NewRecorder()
+			MaxURILengthMiddleware(tt.max)(r).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.uri, nil))
+			testutil.AssertStatus(t, rec, tt.want)
+		})
+	}
+}
+
+func TestGlobalTimeoutMiddleware(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		path    string
+		delay   time.Duration
+		want    int
+	}{
+		{"timed out", 10 * time.Millisecond, "/account/1", 50 * time.Millisecond, http.StatusServiceUnavailable},
+		{"excluded", 10 * time.Millisecond, "/account/1/events", 50 * time.Millisecond, http.StatusOK},
+		{"pattern needs every segment", 10 * time.Millisecond, "/account/1/events/x", 50 * time.Millisecond, http.StatusServiceUnavailable},
+		{"disabled", 0, "/account/1", 20 * time.Millisecond, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done := make(chan struct{})
+			h := GlobalTimeoutMiddleware(tt.timeout, "/account/{id}/events")(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				defer close(done)
+				time.Sleep(tt.delay)
+			}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			<-done
+
+			testutil.AssertStatus(t, rec, tt.want)
+			if tt.want == http.StatusServiceUnavailable {
+				if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+					t.Errorf("Content-Type = %q, want application/json", ct)
+				}
+				if !strings.Contains(rec.Body.String(), "request timed out") {
+					t.Errorf("body = %s", rec.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestUserAgentFilterMiddleware(t *testing.T) {
+	blocklist := []string{"BadBot", "scrapy", ""}
+	tests := []struct {
+		name     string
+		required bool
+		ua       string
+		want     int
+	}{
+		{"empty required", true, "", http.StatusForbidden},
+		{"blank required", true, "   ", http.StatusForbidden},
+		{"empty allowed", false, "", http.StatusOK},
+		{"blocked", true, "BadBot/2.0", http.StatusForbidden},
+		{"blocked case-insensitive", true, "Mozilla/5.0 (compatible; badbot)", http.StatusForbidden},
+		{"blocked substring", false, "Scrapy/2.11 (+https://scrapy.org)", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/account/1", nil)
+			req.Header.Set("User-Agent", tt.ua)
+			var rec *httptest.ResponseRecorder
+			var next *http.Request
+			captureStdout(t, func() { rec, next = testutil.InvokeMiddleware(UserAgentFilterMiddleware(blocklist, tt.required), req) })
+			testutil.AssertStatus(t, rec, tt.want)
+			if called := next != nil; called != (tt.want == http.StatusOK) {
+				t.Errorf("next called = %v", called)
+			}
+		})
+	}
+}
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGzipRequestMiddleware(t *testing.T) {
+	const payload = `{"name":"a"}`
+	valid := gzipped(t, payload)
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		max      int64
+		want     int
+		wantBody string
+	}{
+		{"valid gzip", "gzip", valid, 1 << 10, http.StatusOK, payload},
+		{"x-gzip", "X-Gzip", valid, 1 << 10, http.StatusOK, payload},
+		{"not gzipped", "", []byte(payload), 1 << 10, http.StatusOK, payload},
+		{"malformed header", "gzip", []byte("not gzip at all"), 1 << 10, http.StatusBadRequest, ""},
+		{"truncated stream", "gzip", valid[:len(valid)-6], 1 << 10, http.StatusBadRequest, ""},
+		{"over decompressed limit", "gzip", gzipped(t, strings.Repeat("a", 1<<16)), 1 << 10, http.StatusRequestEntityTooLarge, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := GzipRequestMiddleware(tt.max)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				b, _ := io.ReadAll(req.Body)
+				got = string(b)
+				if req.Header.Get("Content-Encoding") != "" {
+					t.Error("Content-Encoding left on the decompressed request")
+				}
+			}))
+			req := httptest.NewRequest(http.MethodPut, "/account/1", bytes.NewReader(tt.body))
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			testutil.AssertStatus(t, rec, tt.want)
+			if got != tt.wantBody {
+				t.Errorf("handler read %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAbandonedRequests(t *testing.T) {
+	tests := []struct {
+		name string
+		mw   mux.MiddlewareFunc
+	}{
+		{"authenticate", AuthenticateMiddleware(AuthConfig{})},
+		{"account auth", AccountAuthMiddleware(AuthConfig{})},
+		{"logging", ToMux(LoggingFunc())},
+	}
+	for _, tt := range tests {
+		for _, cancelled := range []bool{true, false} {
+			name := tt.name + " live"
+			if cancelled {
+				name = tt.name + " cancelled"
+			}
+			t.Run(name, func(t *testing.T) {
+				req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/account/1", nil), map[string]string{"id": "1"})
+				req.Header.Set("Authorization", "1")
+				if cancelled {
+					ctx, cancel := context.WithCancel(req.Context())
+					cancel()
+					req = req.WithContext(ctx)
+				}
+				var rec *httptest.ResponseRecorder
+				var next *http.Request
+				out := captureStdout(t, func() { rec, next = testutil.InvokeMiddleware(tt.mw, req) })
+
+				if called := next != nil; called == cancelled {
+					t.Errorf("next called = %v for cancelled = %v", called, cancelled)
+				}
+				if cancelled && (rec.Body.Len() > 0 || out != "") {
+					t.Errorf("abandoned request wrote %q and logged %q", rec.Body, out)
+				}
+			})
+		}
+	}
+}
+
+func TestTrailingSlashMiddleware(t *testing.T) {
+	tests := []struct {
+		name         string
+		mode         TrailingSlashMode
+		method       string
+		target       string
+		want         int
+		wantLocation string
+		wantID       string // id the handler sees, if reached
+	}{
+		{"redirect GET", TrailingSlashRedirect, http.MethodGet, "/account/123/", http.StatusMovedPermanently, "/account/123", ""},
+		{"redirect HEAD", TrailingSlashRedirect, http.MethodHead, "/account/123/", http.StatusMovedPermanently, "/account/123", ""},
+		{"redirect POST keeps method", TrailingSlashRedirect, http.MethodPost, "/account/123/", http.StatusPermanentRedirect, "/account/123", ""},
+		{"redirect keeps query", TrailingSlashRedirect, http.MethodGet, "/account/123/?fields=name", http.StatusMovedPermanently, "/account/123?fields=name", ""},
+		{"redirect leaves root", TrailingSlashRedirect, http.MethodGet, "/", http.StatusNotFound, "", ""},
+		{"strip GET", TrailingSlashStrip, http.MethodGet, "/account/123/", http.StatusOK, "", "123"},
+		{"strip POST", TrailingSlashStrip, http.MethodPost, "/account/123//", http.StatusOK, "", "123"},
+		{"off GET", TrailingSlashOff, http.MethodGet, "/account/123/", http.StatusNotFound, "", ""},
+		{"off POST", TrailingSlashOff, http.MethodPost, "/account/123/", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			r := NewRouter()
+			r.HandleFunc("/account/{id}", func(w http.ResponseWriter, req *http.Request) {
+				seen = mux.Vars(req)["id"]
+			}).Methods(http.MethodGet, http.MethodHead, http.MethodPost)
+			h := TrailingSlashMiddleware(tt.mode)(r)
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+			if seen != tt.wantID {
+				t.Errorf("handler saw id %q, want %q", seen, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestHeaderLimitMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxCount int
+		maxBytes int
+		headers  int    // number of X-N headers sent
+		value    string // value of each
+		want     int
+	}{
+		{"normal", 10, 1024, 3, "v", http.StatusOK},
+		{"at count limit", 3, 0, 3, "v", http.StatusOK},
+		{"over count", 3, 0, 4, "v", http.StatusRequestHeaderFieldsTooLarge},
+		{"at size limit", 0, 4 * len("X-N: v\r\n"), 4, "v", http.StatusOK},
+		{"over size", 0, 1024, 1, strings.Repeat("v", 1024), http.StatusRequestHeaderFieldsTooLarge},
+		{"no limits", 0, 0, 200, strings.Repeat("v", 100), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for i := 0; i < tt.headers; i++ {
+				req.Header.Add("X-N", tt.value)
+			}
+			rec, next := testutil.InvokeMiddleware(HeaderLimitMiddleware(tt.maxCount, tt.maxBytes), req)
+			testutil.AssertStatus(t, rec, tt.want)
+			if called, wantCalled := next != nil, tt.want == http.StatusOK; called != wantCalled {
+				t.Errorf("next called = %v, want %v", called, wantCalled)
+			}
+		})
+	}
+}
+
+func TestRequireContentLength(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		body    string
+		chunked bool // send the body without a Content-Length
+		want    int
+	}{
+		{"chunked POST", http.MethodPost, `{"id":"1"}`, true, http.StatusLengthRequired},
+		{"chunked PUT", http.MethodPut, `{"id":"1"}`, true, http.StatusLengthRequired},
+		{"POST with Content-Length", http.MethodPost, `{"id":"1"}`, false, http.StatusOK},
+		{"POST without body", http.MethodPost, "", false, http.StatusOK},
+		{"chunked PATCH", http.MethodPatch, `{"id":"1"}`, true, http.StatusOK},
+	}
+	srv := httptest.NewServer(RequireContentLength(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.Copy(io.Discard, req.Body)
+	})))
+	defer srv.Close()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+				if tt.chunked {
+					// hide the length so the client falls back to chunked encoding
+					body = struct{ io.Reader }{body}
+				}
+			}
+			req, _ := http.NewRequest(tt.method, srv.URL, body)
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathTraversalMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{"normal id", "/account/123", http.StatusOK},
+		{"dots inside an id", "/account/a..b", http.StatusOK},
+		{"dotted version", "/account/v1.2.3", http.StatusOK},
+		{"dots in a query value", "/account/1?next=../../etc/passwd", http.StatusOK},
+		{"encoded slashes", "/account/..%2f..%2fetc", http.StatusBadRequest},
+		{"literal dot-dot", "/account/../admin", http.StatusBadRequest},
+		{"single dot", "/account/./1", http.StatusBadRequest},
+		{"encoded dots", "/account/%2e%2e/admin", http.StatusBadRequest},
+		{"double-encoded", "/account/%252e%252e%252fetc", http.StatusBadRequest},
+		{"backslashes", "/account/..%5c..%5cwindows", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			h := PathTraversalMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { called = true }))
+			rec := httptest.NewRecorder()
+			captureStdout(t, func() { h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil)) })
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if called != (tt.want == http.StatusOK) {
+				t.Errorf("handler called = %v", called)
+			}
+		})
+	}
+}
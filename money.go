@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidMoney = errors.New("invalid money amount")
+
+// Money is an amount in minor units (cents). It is held as an integer so
+// arithmetic is exact, and travels in JSON as a decimal string such as
+// "12.34" so no client parses it into a float.
+type Money int64
+
+// ParseMoney parses a decimal amount with at most two fractional digits,
+// such as "12", "-0.5" or "12.34"
+func ParseMoney(s string) (Money, error) {
+	neg := strings.HasPrefix(s, "-")
+	if neg {
+		s = s[1:]
+	}
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+		if frac == "" {
+			return 0, ErrInvalidMoney
+		}
+	}
+	if whole == "" || len(frac) > 2 || !isDigits(whole) || !isDigits(frac) {
+		return 0, ErrInvalidMoney
+	}
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || units > math.MaxInt64/100 {
+		return 0, ErrInvalidMoney
+	}
+	var cents int64
+	if frac != "" {
+		cents, _ = strconv.ParseInt(frac, 10, 64)
+		if len(frac) == 1 {
+			cents *= 10
+		}
+	}
+	m := units*100 + cents
+	if m < 0 {
+		return 0, ErrInvalidMoney
+	}
+	if neg {
+		m = -m
+	}
+	return Money(m), nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// IsZero reports whether m is zero, for MarshalOptions.OmitEmpty, which
+// would otherwise only see the non-empty string "0.00"
+func (m Money) IsZero() bool {
+	return m == 0
+}
+
+// String formats m with two fractional digits
+func (m Money) String() string {
+	sign, n := "", int64(m)
+	if n < 0 {
+		sign = "-"
+	}
+	u := uint64(n)
+	if n < 0 {
+		u = uint64(-(n + 1)) + 1 // no overflow for math.MinInt64
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, u/100, u%100)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(m.String())), nil
+}
+
+// UnmarshalJSON accepts the decimal string form and, for older clients, a
+// bare JSON number, which is parsed from its text rather than via float64
+func (m *Money) UnmarshalJSON(b []byte) error {
+	s := string(bytes.TrimSpace(b))
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	v, err := ParseMoney(s)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidMoney, s)
+	}
+	*m = v
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestMoneyRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string // JSON
+		want Money
+		out  string // JSON it marshals back to
+	}{
+		{"string", `"12.34"`, 1234, `"12.34"`},
+		{"one fractional digit", `"0.1"`, 10, `"0.10"`},
+		{"float would mangle", `"0.3"`, 30, `"0.30"`},
+		{"bare number", `0.29`, 29, `"0.29"`},
+		{"large bare number", `90071992547409.93`, 9007199254740993, `"90071992547409.93"`},
+		{"negative", `"-0.05"`, -5, `"-0.05"`},
+		{"whole", `"7"`, 700, `"7.00"`},
+		{"largest", `"92233720368547758.07"`, math.MaxInt64, `"92233720368547758.07"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m Money
+			if err := json.Unmarshal([]byte(tt.in), &m); err != nil {
+				t.Fatalf("Unmarshal(%s): %v", tt.in, err)
+			}
+			if m != tt.want {
+				t.Errorf("Unmarshal(%s) = %d, want %d", tt.in, m, tt.want)
+			}
+			out, err := json.Marshal(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tt.out {
+				t.Errorf("Marshal = %s, want %s", out, tt.out)
+			}
+		})
+	}
+}
+
+func TestMoneySum(t *testing.T) {
+	var a, b Money
+	json.Unmarshal([]byte(`"0.1"`), &a)
+	json.Unmarshal([]byte(`"0.2"`), &b)
+	if got, _ := json.Marshal(a + b); string(got) != `"0.30"` {
+		t.Errorf("0.1 + 0.2 = %s, want \"0.30\"", got)
+	}
+}
+
+func TestMoneyUnmarshalInvalid(t *testing.T) {
+	for _, in := range []string{`"1.234"`, `"1."`, `".5"`, `"abc"`, `"-"`, `"1e3"`, `"92233720368547758.08"`, `true`} {
+		t.Run(in, func(t *testing.T) {
+			var m Money
+			if err := json.Unmarshal([]byte(in), &m); !errors.Is(err, ErrInvalidMoney) {
+				t.Errorf("Unmarshal(%s) = %v, want ErrInvalidMoney", in, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientCertAuthMiddleware(t *testing.T) {
+	cert := func(cn string, sans ...string) *x509.Certificate {
+		return &x509.Certificate{Subject: pkix.Name{CommonName: cn}, DNSNames: sans}
+	}
+	verified := func(c *x509.Certificate) *tls.ConnectionState {
+		return &tls.ConnectionState{PeerCertificates: []*x509.Certificate{c}, VerifiedChains: [][]*x509.Certificate{{c}}}
+	}
+	allowed := []string{"billing", "reports.internal"}
+	tests := []struct {
+		name       string
+		tls        *tls.ConnectionState
+		wantStatus int
+		wantID     string
+	}{
+		{"allowed CN", verified(cert("billing")), http.StatusOK, "billing"},
+		{"allowed SAN", verified(cert("reports", "other.internal", "reports.internal")), http.StatusOK, "reports.internal"},
+		{"CN not allowed", verified(cert("intruder")), http.StatusForbidden, ""},
+		{"empty CN", verified(cert("")), http.StatusForbidden, ""},
+		{"no TLS", nil, http.StatusUnauthorized, ""},
+		{"no certificate", &tls.ConnectionState{}, http.StatusUnauthorized, ""},
+		{"unverified certificate", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert("billing")}}, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var id string
+			h := ClientCertAuthMiddleware(allowed)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if p, ok := PrincipalFromContext(req.Context()); ok {
+					id = p.ID
+				}
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/account/1", nil)
+			req.TLS = tt.tls
+			rec := httptest.NewRecorder()
+			captureStdout(t, func() { h.ServeHTTP(rec, req) })
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if id != tt.wantID {
+				t.Errorf("principal = %q, want %q", id, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestMinTLSVersionMiddleware(t *testing.T) {
+	tests := []struct {
+		name        string
+		tls         *tls.ConnectionState
+		want        int
+		wantUpgrade string
+	}{
+		{"TLS 1.2", &tls.ConnectionState{Version: tls.VersionTLS12}, http.StatusOK, ""},
+		{"TLS 1.1", &tls.ConnectionState{Version: tls.VersionTLS11}, http.StatusUpgradeRequired, "TLS/1.2, HTTP/1.1"},
+		{"plain HTTP", nil, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := MinTLSVersionMiddleware(tls.VersionTLS12)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.TLS = tt.tls
+			rec := httptest.NewRecorder()
+			captureStdout(t, func() { h.ServeHTTP(rec, req) })
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if got := rec.Header().Get("Upgrade"); got != tt.wantUpgrade {
+				t.Errorf("Upgrade = %q, want %q", got, tt.wantUpgrade)
+			}
+		})
+	}
+}
+
+func TestMinTLSVersionMiddlewareOverTLS(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxVersion uint16 // the highest version the client offers
+		want       int
+	}{
+		{"TLS 1.2", tls.VersionTLS12, http.StatusOK},
+		{"forced TLS 1.1", tls.VersionTLS11, http.StatusUpgradeRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewUnstartedServer(MinTLSVersionMiddleware(tls.VersionTLS12)(http.HandlerFunc(Healthz)))
+			srv.TLS = &tls.Config{MinVersion: tls.VersionTLS10}
+			srv.StartTLS()
+			defer srv.Close()
+			client := srv.Client()
+			transport := client.Transport.(*http.Transport)
+			transport.TLSClientConfig.MinVersion = tls.VersionTLS10
+			transport.TLSClientConfig.MaxVersion = tt.maxVersion
+
+			var resp *http.Response
+			var err error
+			captureStdout(t, func() { resp, err = client.Get(srv.URL) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
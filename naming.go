@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// FieldNaming selects how Account field names are spelled in JSON
+type FieldNaming int
+
+const (
+	// NamingTags uses the names from the struct tags
+	NamingTags FieldNaming = iota
+	// NamingSnake spells every field name in snake_case
+	NamingSnake
+	// NamingCamel spells every field name in camelCase
+	NamingCamel
+)
+
+// AccountNaming is the naming policy Account.MarshalJSON applies. It is
+// meant to be set once at startup.
+var AccountNaming = NamingTags
+
+func (a Account) MarshalJSON() ([]byte, error) {
+	type plain Account
+	if AccountNaming == NamingTags {
+		return json.Marshal(plain(a))
+	}
+	return json.Marshal(a.jsonFields())
+}
+
+// jsonFields returns the fields MarshalJSON encodes, keyed by their JSON
+// names, so OmitEmpty can inspect their Go values
+func (a Account) jsonFields() map[string]interface{} {
+	return renameFields(reflect.ValueOf(a), AccountNaming)
+}
+
+// renameFields flattens struct v into a map keyed by its field names
+// (taken from the json tag, else the Go name), re-spelled under the given
+// policy unless it is NamingTags. Fields tagged "-" are skipped.
+func renameFields(v reflect.Value, naming FieldNaming) map[string]interface{} {
+	t := v.Type()
+	out := make(map[string]interface{}, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.PkgPath != "" || tag == "-" {
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		name := tag
+		if naming != NamingTags {
+			name = toSnake(tag)
+		}
+		if naming == NamingCamel {
+			name = snakeToCamel(name)
+		}
+		out[name] = v.Field(i).Interface()
+	}
+	return out
+}
+
+// toSnake converts a Go identifier to snake_case, keeping initialisms
+// together: "AccountID" becomes "account_id"
+func toSnake(s string) string {
+	rs := []rune(s)
+	var b strings.Builder
+	for i, r := range rs {
+		if unicode.IsUpper(r) && i > 0 {
+			prevLower := unicode.IsLower(rs[i-1])
+			nextLower := i+1 < len(rs) && unicode.IsLower(rs[i+1])
+			if prevLower || (unicode.IsUpper(rs[i-1]) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+func snakeToCamel(s string) string {
+	parts := strings.Split(s, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAccountNaming(t *testing.T) {
+	a := Account{ID: "1", Name: "alice", Balance: 250}
+	tests := []struct {
+		name   string
+		naming FieldNaming
+		want   string
+	}{
+		{"tags", NamingTags, `{"account_id":"1","name":"alice","balance":"2.50"}`},
+		{"snake", NamingSnake, `{"account_id":"1","balance":"2.50","name":"alice"}`},
+		{"camel", NamingCamel, `{"accountId":"1","balance":"2.50","name":"alice"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(n FieldNaming) { AccountNaming = n }(AccountNaming)
+			AccountNaming = tt.naming
+			got, err := json.Marshal(a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Marshal = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToSnake(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"account_id", "account_id"},
+		{"AccountID", "account_id"},
+		{"createdAt", "created_at"},
+		{"HTTPStatus", "http_status"},
+		{"Name", "name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := toSnake(tt.in); got != tt.want {
+				t.Errorf("toSnake(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
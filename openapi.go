@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Types documented under components/schemas, by name
+var openAPISchemas = map[string]interface{}{
+	"Account":  Account{},
+	"APIError": APIError{},
+}
+
+// GenerateOpenAPI describes the routes registered on r as a minimal OpenAPI
+// 3.0 document: every path with its methods and path parameters, plus the
+// Account and APIError schemas derived from their structs. Routes that
+// accept any method are listed under GET.
+func GenerateOpenAPI(r *mux.Router) ([]byte, error) {
+	routes, err := ListRoutes(r)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := map[string]map[string]interface{}{}
+	for _, route := range routes {
+		path, params := openAPIPath(route.Path)
+		item, ok := paths[path]
+		if !ok {
+			item = map[string]interface{}{}
+			paths[path] = item
+		}
+		methods := route.Methods
+		if len(methods) == 0 {
+			methods = []string{http.MethodGet}
+		}
+		for _, m := range methods {
+			op := map[string]interface{}{
+				"operationId": route.Handler,
+				"responses": map[string]interface{}{
+					"default": map[string]interface{}{
+						"description": "error",
+						"content": map[string]interface{}{
+							"application/json": map[string]interface{}{
+								"schema": map[string]string{"$ref": "#/components/schemas/APIError"},
+							},
+						},
+					},
+				},
+			}
+			if len(params) > 0 {
+				op["parameters"] = params
+			}
+			item[strings.ToLower(m)] = op
+		}
+	}
+
+	schemas := map[string]interface{}{}
+	for name, v := range openAPISchemas {
+		schemas[name] = schemaOf(reflect.TypeOf(v))
+	}
+
+	return json.MarshalIndent(map[string]interface{}{
+		"openapi":    "3.0.3",
+		"info":       map[string]string{"title": "Account API", "version": "1.0.0"},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}, "", "  ")
+}
+
+// openAPIPath turns a mux template into an OpenAPI path, dropping any
+// "{id:[0-9]+}" patterns, and lists its path parameters
+func openAPIPath(tpl string) (string, []map[string]interface{}) {
+	var params []map[string]interface{}
+	segs := strings.Split(tpl, "/")
+	for i, seg := range segs {
+		if !strings.HasPrefix(seg, "{") || !strings.HasSuffix(seg, "}") {
+			continue
+		}
+		name := strings.SplitN(seg[1:len(seg)-1], ":", 2)[0]
+		segs[i] = "{" + name + "}"
+		params = append(params, map[string]interface{}{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]string{"type": "string"},
+		})
+	}
+	return strings.Join(segs, "/"), params
+}
+
+// schemaOf derives a JSON schema for t from its kind and json tags
+func schemaOf(t reflect.Type) map[string]interface{} {
+	if t == reflect.TypeOf(Money(0)) {
+		return map[string]interface{}{"type": "string", "format": "decimal"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Struct:
+		props := map[string]interface{}{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := strings.Split(f.Tag.Get("json"), ",")[0]
+			if f.PkgPath != "" || tag == "-" {
+				continue
+			}
+			if tag == "" {
+				tag = f.Name
+			}
+			props[tag] = schemaOf(f.Type)
+		}
+		return map[string]interface{}{"type": "object", "properties": props}
+	}
+	return map[string]interface{}{}
+}
+
+// OpenAPIHandler serves GenerateOpenAPI(r), e.g. at /openapi.json. The
+// document is built per request so it reflects routes added later.
+func OpenAPIHandler(r *mux.Router) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		doc, err := GenerateOpenAPI(r)
+		if err != nil {
+			writeError(rw, err)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write(doc)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestGenerateOpenAPI(t *testing.T) {
+	r := NewRouter()
+	RegisterAccountRoutes(r, NewMapStore(), AuthConfig{}, nil)
+	r.HandleFunc("/item/{sku:[a-z]+}", Healthz).Methods(http.MethodGet)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+			Parameters  []struct {
+				Name string `json:"name"`
+				In   string `json:"in"`
+			} `json:"parameters"`
+		} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]interface{} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("document is not JSON: %v", err)
+	}
+	if doc.OpenAPI != "3.0.3" {
+		t.Errorf("openapi = %q", doc.OpenAPI)
+	}
+
+	tests := []struct {
+		path, method string
+		wantOp       string
+		wantParams   []string
+	}{
+		{"/account/{id}", "get", "", []string{"id"}},
+		{"/account/{id}", "patch", "PatchAccount", []string{"id"}},
+		{"/accounts", "post", "CreateAccount", nil},
+		{"/item/{sku}", "get", "Healthz", []string{"sku"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			op, ok := doc.Paths[tt.path][tt.method]
+			if !ok {
+				t.Fatalf("missing from paths %v", doc.Paths)
+			}
+			if tt.wantOp != "" && op.OperationID != tt.wantOp {
+				t.Errorf("operationId = %q, want %q", op.OperationID, tt.wantOp)
+			}
+			var params []string
+			for _, p := range op.Parameters {
+				if p.In != "path" {
+					t.Errorf("parameter %s in %q", p.Name, p.In)
+				}
+				params = append(params, p.Name)
+			}
+			if !reflect.DeepEqual(params, tt.wantParams) {
+				t.Errorf("parameters = %v, want %v", params, tt.wantParams)
+			}
+		})
+	}
+
+	for schema, field := range map[string]string{"Account": "account_id", "APIError": "message"} {
+		if _, ok := doc.Components.Schemas[schema].Properties[field]; !ok {
+			t.Errorf("schema %s lacks %s: %+v", schema, field, doc.Components.Schemas[schema])
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Principal is the authenticated caller
+type Principal struct {
+	ID    string   `json:"id"`
+	Roles []string `json:"roles"`
+
+	// Tenant is the tenant the caller's token is bound to, if any
+	Tenant string `json:"tenant,omitempty"`
+
+	// TokenID is the jti of the Bearer token the caller authenticated
+	// with, if any
+	TokenID string `json:"-"`
+}
+
+// WithPrincipal returns a copy of ctx carrying p
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal stored by the auth middleware
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// HasRole reports whether p was granted role
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// mayAccess reports whether p may act on account id: its own, or any when
+// p is an admin
+func (p *Principal) mayAccess(id string) bool {
+	return p.ID == id || p.HasRole(adminRole)
+}
+
+// RequireRole lets through only callers whose Principal has role, answering
+// 403 otherwise. It goes after the auth middleware that sets the Principal.
+func RequireRole(role string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if p, ok := PrincipalFromContext(req.Context()); !ok || !p.HasRole(role) {
+				writeError(w, &APIError{HTTPStatus: http.StatusForbidden, ErrorCode: "forbidden", Message: role + " role required", Err: ErrForbidden})
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// WhoAmI reports the authenticated principal, or 401 if there is none
+func WhoAmI(rw http.ResponseWriter, req *http.Request) {
+	p, ok := PrincipalFromContext(req.Context())
+	if !ok {
+		respondJSON(rw, http.StatusUnauthorized, map[string]string{"error": "unauthenticated"})
+		return
+	}
+	roles := p.Roles
+	if roles == nil {
+		roles = []string{}
+	}
+	respondJSON(rw, http.StatusOK, Principal{ID: p.ID, Roles: roles, Tenant: p.Tenant})
+}
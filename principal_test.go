@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWhoAmI(t *testing.T) {
+	tests := []struct {
+		name       string
+		principal  *Principal
+		wantStatus int
+		wantBody   string
+	}{
+		{"with roles", &Principal{ID: "u1", Roles: []string{"admin", "ops"}, TokenID: "jti-1"}, http.StatusOK, `{"id":"u1","roles":["admin","ops"]}`},
+		{"no roles", &Principal{ID: "u2"}, http.StatusOK, `{"id":"u2","roles":[]}`},
+		{"unauthenticated", nil, http.StatusUnauthorized, `{"error":"unauthenticated"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/me", nil)
+			if tt.principal != nil {
+				req = req.WithContext(WithPrincipal(req.Context(), tt.principal))
+			}
+			rec := httptest.NewRecorder()
+			WhoAmI(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.wantBody {
+				t.Errorf("body = %s, want %s", got, tt.wantBody)
+			}
+		})
+	}
+}
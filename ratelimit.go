@@ -0,0 +1,165 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How often RateLimiter looks for idle buckets to drop
+const bucketSweepInterval = time.Minute
+
+// RateLimit allows Requests requests per Window, refilled continuously, with
+// bursts of up to Requests. A zero Requests disables the limit; otherwise
+// Window must be positive.
+type RateLimit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// RateLimitConfig sets the limits RateLimiter applies. Callers with a
+// Principal in the request context are limited by their id; everyone else
+// is limited by client IP, which covers login and other pre-auth routes.
+type RateLimitConfig struct {
+	PerID RateLimit
+	PerIP RateLimit
+
+	// Clock refills the buckets; nil means the real clock
+	Clock Clock
+}
+
+type bucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter is a token-bucket limiter keyed by principal id or client IP.
+// Register it after the auth middleware so the principal is known. A
+// bucket left idle for its whole window has refilled, so it is dropped and
+// recreated full on the caller's next request; memory grows only with the
+// callers seen within a window, not with every caller ever seen.
+type RateLimiter struct {
+	cfg   RateLimitConfig
+	clock Clock
+
+	mu        sync.RWMutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewRateLimiter returns a limiter applying cfg. It panics if an enabled
+// limit has a non-positive Window, as a configuration mistake.
+func NewRateLimiter(cfg RateLimitConfig) *RateLimiter {
+	for _, limit := range []RateLimit{cfg.PerID, cfg.PerIP} {
+		if limit.Requests > 0 && limit.Window <= 0 {
+			panic("NewRateLimiter: window must be positive")
+		}
+	}
+	clock := clockOrReal(cfg.Clock)
+	return &RateLimiter{cfg: cfg, clock: clock, buckets: map[string]*bucket{}, lastSweep: clock.Now()}
+}
+
+// Middleware answers 429 with Retry-After once the caller's bucket is empty
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key, limit := l.keyFor(req)
+		if limit.Requests > 0 {
+			if wait, ok := l.take(key, limit); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				respondJSON(w, http.StatusTooManyRequests, map[string]string{"error": "rate limit exceeded"})
+				return
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// keyFor picks the bucket for req: the principal id when authenticated,
+// otherwise the client IP. The two are prefixed so they never collide.
+func (l *RateLimiter) keyFor(req *http.Request) (string, RateLimit) {
+	if p, ok := PrincipalFromContext(req.Context()); ok {
+		return "id:" + p.ID, l.cfg.PerID
+	}
+	return "ip:" + clientIP(req), l.cfg.PerIP
+}
+
+// take spends a token from key's bucket, or reports how long until one is
+// available
+func (l *RateLimiter) take(key string, limit RateLimit) (time.Duration, bool) {
+	rate := float64(limit.Requests) / limit.Window.Seconds()
+	now := l.clock.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) >= bucketSweepInterval {
+		l.sweepLocked(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limit: limit, tokens: float64(limit.Requests), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Requests), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / rate * float64(time.Second)), false
+	}
+	b.tokens--
+	return 0, true
+}
+
+// sweepLocked drops the buckets idle long enough to have refilled
+func (l *RateLimiter) sweepLocked(now time.Time) {
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= b.limit.Window {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// LimiterState describes one bucket of a RateLimiter
+type LimiterState struct {
+	Key string `json:"key"`
+	// Tokens is what the bucket holds now, refill included
+	Tokens   float64   `json:"remaining_tokens"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Snapshot returns the state of every bucket, sorted by key. It is a copy,
+// safe to keep while the limiter carries on.
+func (l *RateLimiter) Snapshot() []LimiterState {
+	now := l.clock.Now()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	states := make([]LimiterState, 0, len(l.buckets))
+	for key, b := range l.buckets {
+		rate := float64(b.limit.Requests) / b.limit.Window.Seconds()
+		states = append(states, LimiterState{
+			Key:      key,
+			Tokens:   math.Min(float64(b.limit.Requests), b.tokens+now.Sub(b.last).Seconds()*rate),
+			LastSeen: b.last,
+		})
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Key < states[j].Key })
+	return states
+}
+
+// SnapshotHandler serves Snapshot as JSON
+func (l *RateLimiter) SnapshotHandler(w http.ResponseWriter, req *http.Request) {
+	respondJSON(w, http.StatusOK, l.Snapshot())
+}
+
+// clientIP is the host part of the connection's remote address.
+// Forwarding headers are ignored since any client can set them.
+func clientIP(req *http.Request) string {
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit RateLimit
+		gaps  []time.Duration // clock advance before each request
+		want  []int
+	}{
+		{"burst then throttled", RateLimit{Requests: 2, Window: time.Minute},
+			[]time.Duration{0, 0, 0}, []int{200, 200, 429}},
+		{"refills over the window", RateLimit{Requests: 1, Window: time.Minute},
+			[]time.Duration{0, 30 * time.Second, 30 * time.Second}, []int{200, 429, 200}},
+		{"disabled", RateLimit{},
+			[]time.Duration{0, 0, 0}, []int{200, 200, 200}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock(time.Unix(0, 0))
+			h := NewRateLimiter(RateLimitConfig{PerIP: tt.limit, Clock: clock}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+			for i, gap := range tt.gaps {
+				clock.Advance(gap)
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+				if rec.Code != tt.want[i] {
+					t.Errorf("request %d: status = %d, want %d", i+1, rec.Code, tt.want[i])
+				}
+				if rec.Code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+					t.Errorf("request %d: 429 without Retry-After", i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterEvictsIdleBuckets(t *testing.T) {
+	clock := newFakeClock(time.Unix(0, 0))
+	limit := RateLimit{Requests: 5, Window: time.Minute}
+	l := NewRateLimiter(RateLimitConfig{PerIP: limit, Clock: clock})
+	for i := 0; i < 1000; i++ {
+		l.take(fmt.Sprintf("ip:10.0.%d.%d", i/256, i%256), limit)
+	}
+	clock.Advance(bucketSweepInterval)
+	l.take("ip:10.1.0.1", limit)
+	if n := len(l.Snapshot()); n != 1 {
+		t.Errorf("buckets after sweep = %d, want 1", n)
+	}
+}
+
+func TestNewRateLimiterRejectsNonPositiveWindow(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       RateLimitConfig
+		wantPanic bool
+	}{
+		{"zero window", RateLimitConfig{PerIP: RateLimit{Requests: 1}}, true},
+		{"negative window", RateLimitConfig{PerID: RateLimit{Requests: 1, Window: -time.Second}}, true},
+		{"disabled limit", RateLimitConfig{PerIP: RateLimit{}}, false},
+		{"valid", RateLimitConfig{PerIP: RateLimit{Requests: 1, Window: time.Second}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if got := recover() != nil; got != tt.wantPanic {
+					t.Errorf("panicked = %v, want %v", got, tt.wantPanic)
+				}
+			}()
+			NewRateLimiter(tt.cfg)
+		})
+	}
+}
+
+func TestRateLimiterSnapshot(t *testing.T) {
+	limit := RateLimit{Requests: 4, Window: time.Minute}
+	tests := []struct {
+		name       string
+		takes      map[string]int // requests per key
+		advance    time.Duration  // clock advance before the snapshot
+		wantTokens map[string]float64
+	}{
+		{"empty", nil, 0, map[string]float64{}},
+		{"consumed", map[string]int{"ip:a": 1, "ip:b": 3}, 0, map[string]float64{"ip:a": 3, "ip:b": 1}},
+		{"exhausted", map[string]int{"ip:a": 6}, 0, map[string]float64{"ip:a": 0}},
+		{"refill included", map[string]int{"ip:a": 4}, 30 * time.Second, map[string]float64{"ip:a": 2}},
+		{"refill capped", map[string]int{"ip:a": 1}, 30 * time.Second, map[string]float64{"ip:a": 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock(time.Unix(0, 0))
+			l := NewRateLimiter(RateLimitConfig{PerIP: limit, Clock: clock})
+			for key, n := range tt.takes {
+				for i := 0; i < n; i++ {
+					l.take(key, limit)
+				}
+			}
+			clock.Advance(tt.advance)
+
+			snap := l.Snapshot()
+			if len(snap) != len(tt.wantTokens) {
+				t.Fatalf("snapshot = %+v, want %d buckets", snap, len(tt.wantTokens))
+			}
+			for i, s := range snap {
+				if i > 0 && snap[i-1].Key >= s.Key {
+					t.Errorf("snapshot not sorted by key: %+v", snap)
+				}
+				if want := tt.wantTokens[s.Key]; s.Tokens != want {
+					t.Errorf("%s tokens = %v, want %v", s.Key, s.Tokens, want)
+				}
+				if !s.LastSeen.Equal(time.Unix(0, 0)) {
+					t.Errorf("%s last seen = %v", s.Key, s.LastSeen)
+				}
+			}
+
+			// the snapshot is a copy: later traffic leaves it as it was
+			for key := range tt.takes {
+				l.take(key, limit)
+			}
+			for _, s := range snap {
+				if want := tt.wantTokens[s.Key]; s.Tokens != want {
+					t.Errorf("%s tokens changed to %v after more requests", s.Key, s.Tokens)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitsAdminRoute(t *testing.T) {
+	issuer := &TokenIssuer{Secret: []byte("secret"), TTL: time.Hour}
+	admin, err := issuer.Issue("ops", adminRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, err := issuer.Issue("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		requests   int // account requests before the dump
+		token      string
+		want       int
+		wantTokens float64 // tokens left in the caller's IP bucket
+	}{
+		{"fresh", 0, admin, http.StatusOK, 9},
+		{"after traffic", 3, admin, http.StatusOK, 6},
+		{"not admin", 3, owner, http.StatusForbidden, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock(time.Unix(0, 0))
+			l := NewRateLimiter(RateLimitConfig{PerIP: RateLimit{Requests: 10, Window: time.Hour}, Clock: clock})
+			srv, _ := NewTestServer(t, WithAuth(AuthConfig{Tokens: issuer}), WithRateLimiter(l))
+			get := func(path string) *http.Response {
+				req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+				resp, err := srv.Client().Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return resp
+			}
+			for i := 0; i < tt.requests; i++ {
+				get("/account/1").Body.Close()
+			}
+
+			resp := get("/admin/ratelimits")
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			var states []LimiterState
+			if err := json.NewDecoder(resp.Body).Decode(&states); err != nil {
+				t.Fatal(err)
+			}
+			if len(states) != 1 || states[0].Key != "ip:127.0.0.1" || states[0].Tokens != tt.wantTokens {
+				t.Errorf("snapshot = %+v, want ip:127.0.0.1 with %v tokens", states, tt.wantTokens)
+			}
+		})
+	}
+}
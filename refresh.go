@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token invalid")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+type refreshEntry struct {
+	subject string
+	expires time.Time
+	used    bool
+}
+
+// RefreshTokenStore keeps single-use refresh tokens in memory. Redeeming a
+// token rotates it; presenting an already-redeemed token is treated as theft
+// and revokes every outstanding token for that subject. Redeemed tokens are
+// remembered until they would have expired, for that check, and expired
+// ones are swept out as new tokens are issued.
+type RefreshTokenStore struct {
+	TTL   time.Duration
+	Clock Clock // defaults to the real clock
+
+	mu     sync.Mutex
+	tokens map[string]*refreshEntry
+}
+
+// NewRefreshTokenStore returns an empty store issuing tokens valid for ttl
+func NewRefreshTokenStore(ttl time.Duration) *RefreshTokenStore {
+	return &RefreshTokenStore{TTL: ttl, tokens: map[string]*refreshEntry{}}
+}
+
+// Issue creates a new refresh token for subject
+func (s *RefreshTokenStore) Issue(subject string) (string, error) {
+	tok, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	now := clockOrReal(s.Clock).Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for t, e := range s.tokens {
+		if now.After(e.expires) {
+			delete(s.tokens, t)
+		}
+	}
+	s.tokens[tok] = &refreshEntry{subject: subject, expires: now.Add(s.TTL)}
+	return tok, nil
+}
+
+// Rotate redeems tok, returning its subject and a replacement token
+func (s *RefreshTokenStore) Rotate(tok string) (subject, next string, err error) {
+	s.mu.Lock()
+	e, ok := s.tokens[tok]
+	switch {
+	case !ok || clockOrReal(s.Clock).Now().After(e.expires):
+		s.mu.Unlock()
+		return "", "", ErrRefreshTokenInvalid
+	case e.used:
+		s.revokeLocked(e.subject)
+		s.mu.Unlock()
+		return "", "", ErrRefreshTokenReused
+	}
+	e.used = true
+	s.mu.Unlock()
+
+	next, err = s.Issue(e.subject)
+	if err != nil {
+		return "", "", err
+	}
+	return e.subject, next, nil
+}
+
+// Revoke invalidates every refresh token held by subject
+func (s *RefreshTokenStore) Revoke(subject string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revokeLocked(subject)
+}
+
+func (s *RefreshTokenStore) revokeLocked(subject string) {
+	for tok, e := range s.tokens {
+		if e.subject == subject {
+			delete(s.tokens, tok)
+		}
+	}
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// RefreshHandler exchanges a refresh token for a new access token and a
+// rotated refresh token. When cookie is set the access token is also
+// stored in a session cookie of that name, for browser clients.
+func RefreshHandler(issuer *TokenIssuer, store *RefreshTokenStore, cookie string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var body refreshRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.RefreshToken == "" {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "refresh_token required"})
+			return
+		}
+		subject, next, err := store.Rotate(body.RefreshToken)
+		if err != nil {
+			respondJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			return
+		}
+		respondTokens(w, req, issuer, subject, next, cookie)
+	}
+}
+
+// TokenHandler is where a client first gets tokens: it exchanges valid HTTP
+// Basic credentials for an access token and a refresh token to use with
+// RefreshHandler. cookie works as it does for RefreshHandler.
+func TokenHandler(issuer *TokenIssuer, passwords PasswordVerifier, store *RefreshTokenStore, cookie string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		user, pass, ok := req.BasicAuth()
+		if !ok || !passwords.VerifyPassword(user, pass) {
+			w.Header().Set("WWW-Authenticate", basicChallenge)
+			respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid credentials"})
+			return
+		}
+		refresh, err := store.Issue(user)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			return
+		}
+		respondTokens(w, req, issuer, user, refresh, cookie)
+	}
+}
+
+// respondTokens mints an access token for subject and sends it along with
+// the refresh token
+func respondTokens(w http.ResponseWriter, req *http.Request, issuer *TokenIssuer, subject, refresh, cookie string) {
+	access, err := issuer.Issue(subject)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+		return
+	}
+	if cookie != "" {
+		c := newSessionCookie(req, cookie, access)
+		c.MaxAge = int(issuer.TTL / time.Second)
+		http.SetCookie(w, c)
+	}
+	respondJSON(w, http.StatusOK, tokenResponse{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(issuer.TTL / time.Second),
+	})
+}
+
+// RegisterAuthRoutes mounts the token endpoints and GET /me on r.
+// cfg.Tokens issues the access tokens, also set in the cfg.TokenCookie
+// cookie when named. /auth/token is mounted when cfg.Passwords is set to
+// check the credentials, and /auth/revoke, behind authentication, when
+// cfg.Revoked is set.
+func RegisterAuthRoutes(r *mux.Router, cfg AuthConfig, store *RefreshTokenStore) {
+	if cfg.Passwords != nil {
+		r.HandleFunc("/auth/token", TokenHandler(cfg.Tokens, cfg.Passwords, store, cfg.TokenCookie)).Methods(http.MethodPost)
+	}
+	r.HandleFunc("/auth/refresh", RefreshHandler(cfg.Tokens, store, cfg.TokenCookie)).Methods(http.MethodPost)
+	if cfg.Revoked != nil {
+		r.Handle("/auth/revoke", AuthenticateMiddleware(cfg)(RevokeHandler(cfg.Tokens, cfg.Revoked))).Methods(http.MethodPost)
+	}
+	r.Handle("/me", AuthenticateMiddleware(cfg)(http.HandlerFunc(WhoAmI))).Methods(http.MethodGet)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRefreshTokenStoreRotate(t *testing.T) {
+	tests := []struct {
+		name    string
+		redeem  func(s *RefreshTokenStore, clock *fakeClock, tok string) error
+		wantErr error
+		// wantLive is how many tokens remain redeemable afterwards
+		wantLive int
+	}{
+		{
+			name:     "rotates",
+			redeem:   func(s *RefreshTokenStore, _ *fakeClock, tok string) error { _, _, err := s.Rotate(tok); return err },
+			wantLive: 1,
+		},
+		{
+			name: "reuse revokes the subject",
+			redeem: func(s *RefreshTokenStore, _ *fakeClock, tok string) error {
+				if _, _, err := s.Rotate(tok); err != nil {
+					return err
+				}
+				_, _, err := s.Rotate(tok)
+				return err
+			},
+			wantErr: ErrRefreshTokenReused,
+		},
+		{
+			name: "expired",
+			redeem: func(s *RefreshTokenStore, clock *fakeClock, tok string) error {
+				clock.Advance(2 * time.Hour)
+				_, _, err := s.Rotate(tok)
+				return err
+			},
+			wantErr: ErrRefreshTokenInvalid,
+		},
+		{
+			name:    "unknown",
+			redeem:  func(s *RefreshTokenStore, _ *fakeClock, tok string) error { _, _, err := s.Rotate("nope"); return err },
+			wantErr: ErrRefreshTokenInvalid,
+			// the issued token is untouched
+			wantLive: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock(time.Unix(0, 0))
+			s := NewRefreshTokenStore(time.Hour)
+			s.Clock = clock
+			tok, err := s.Issue("1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.redeem(s, clock, tok); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			live := 0
+			for _, e := range s.tokens {
+				if !e.used && !clock.Now().After(e.expires) {
+					live++
+				}
+			}
+			if live != tt.wantLive {
+				t.Errorf("%d live tokens, want %d", live, tt.wantLive)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenStoreSweep(t *testing.T) {
+	clock := newFakeClock(time.Unix(0, 0))
+	s := NewRefreshTokenStore(time.Hour)
+	s.Clock = clock
+
+	used, _ := s.Issue("1")
+	if _, _, err := s.Rotate(used); err != nil {
+		t.Fatal(err)
+	}
+	s.Issue("2")
+	clock.Advance(2 * time.Hour)
+	s.Issue("3")
+
+	if len(s.tokens) != 1 {
+		t.Errorf("%d tokens held after expiry, want 1", len(s.tokens))
+	}
+}
+
+func TestTokenHandler(t *testing.T) {
+	issuer := &TokenIssuer{Secret: []byte("secret"), TTL: time.Minute}
+	cfg := AuthConfig{
+		Tokens: issuer,
+		Passwords: PasswordVerifierFunc(func(user, pass string) bool {
+			return user == "1" && pass == "hunter2"
+		}),
+	}
+	r := NewRouter()
+	RegisterAuthRoutes(r, cfg, NewRefreshTokenStore(time.Hour))
+
+	post := func(path, body string, user, pass string) (*httptest.ResponseRecorder, tokenResponse) {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if user != "" {
+			req.SetBasicAuth(user, pass)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		var resp tokenResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+
+	tests := []struct {
+		name, user, pass string
+		want             int
+	}{
+		{"no credentials", "", "", http.StatusUnauthorized},
+		{"wrong password", "1", "guess", http.StatusUnauthorized},
+		{"valid", "1", "hunter2", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, tokens := post("/auth/token", "", tt.user, tt.pass)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			if claims, err := issuer.Parse(tokens.AccessToken); err != nil || claims.Subject != tt.user {
+				t.Errorf("access token %v, %v", claims, err)
+			}
+			body := `{"refresh_token":"` + tokens.RefreshToken + `"}`
+			if rec, _ := post("/auth/refresh", body, "", ""); rec.Code != http.StatusOK {
+				t.Errorf("refresh = %d, want 200", rec.Code)
+			}
+			if rec, _ := post("/auth/refresh", body, "", ""); rec.Code != http.StatusUnauthorized {
+				t.Errorf("reused refresh = %d, want 401", rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// WithRequestID returns a copy of ctx carrying id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the id stored by RequestIDMiddleware
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok && id != ""
+}
+
+// requestID is the request's id from its context, falling back to the
+// caller's X-Request-ID
+func requestID(req *http.Request) string {
+	if id, ok := RequestIDFromContext(req.Context()); ok {
+		return id
+	}
+	return req.Header.Get("X-Request-ID")
+}
+
+// RequestIDMiddleware stores the caller's X-Request-ID in the request
+// context, generating one when absent, and echoes it on the response so
+// both sides can quote it. Register it ahead of the access log so the log
+// line carries the id.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get("X-Request-ID")
+		if id == "" {
+			var err error
+			if id, err = randomToken(8); err != nil {
+				fmt.Println("request id:", err)
+				next.ServeHTTP(w, req)
+				return
+			}
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, req.WithContext(WithRequestID(req.Context(), id)))
+	})
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// MarshalOptions tweaks how response bodies are encoded
+type MarshalOptions struct {
+	// OmitEmpty drops object fields holding a zero value (0, "", false,
+	// null, an empty array or object, or a value whose IsZero method
+	// reports true, such as a zero Money), at any depth
+	OmitEmpty bool
+}
+
+// respondJSON writes status and then v encoded as JSON. A nil v produces an
+// empty body. v is encoded before anything is written, so if that fails
+// the client gets a 500 APIError rather than status followed by a
+// truncated body.
+func respondJSON(w http.ResponseWriter, status int, v interface{}) {
+	MarshalOptions{}.Respond(w, status, v)
+}
+
+// successEnvelope wraps every successful JSON payload unless the route opts
+// out with RawResponses. Failures keep their {"error": ...} shape.
+type successEnvelope struct {
+	Data      interface{} `json:"data"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// RespondJSON writes a success response with data wrapped as
+// {"data": ..., "request_id": ...}. The request id is the one
+// RequestIDMiddleware stored, or else the caller's X-Request-ID. On
+// RawResponses routes data is written as-is.
+func RespondJSON(w http.ResponseWriter, req *http.Request, status int, data interface{}) {
+	respondJSON(w, status, successBody(req, data))
+}
+
+// successBody returns what RespondJSON would encode for data
+func successBody(req *http.Request, data interface{}) interface{} {
+	if rawResponse(req.Context()) {
+		return data
+	}
+	return successEnvelope{Data: data, RequestID: requestID(req)}
+}
+
+// RawResponses opts the routes it wraps out of the success envelope, for
+// clients that need the bare payload
+func RawResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		next.ServeHTTP(w, req.WithContext(withRawResponse(req.Context())))
+	})
+}
+
+// withRawResponse returns a copy of ctx marked for RawResponses
+func withRawResponse(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rawResponseKey{}, true)
+}
+
+// rawResponse reports whether ctx was marked by RawResponses
+func rawResponse(ctx context.Context) bool {
+	raw, _ := ctx.Value(rawResponseKey{}).(bool)
+	return raw
+}
+
+// Respond is respondJSON with o applied to the encoding of v
+func (o MarshalOptions) Respond(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if v == nil {
+		w.WriteHeader(status)
+		return
+	}
+	body, err := o.encode(errorBody(w, status, v))
+	if err != nil {
+		fmt.Println("respondJSON: encode failed:", err)
+		status = http.StatusInternalServerError
+		body, _ = o.encode(errorBody(w, status, apiErrorFor(err)))
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// encode renders v as a newline-terminated JSON document
+func (o MarshalOptions) encode(v interface{}) ([]byte, error) {
+	if o.OmitEmpty {
+		stripped, err := omitEmpty(v)
+		if err != nil {
+			return nil, err
+		}
+		v = stripped
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// zeroer is implemented by values that know when they are zero even though
+// their JSON form, like Money's "0.00", doesn't look empty
+type zeroer interface {
+	IsZero() bool
+}
+
+// fieldser is implemented by types with their own MarshalJSON that can
+// still expose the Go values of their JSON fields, like Account
+type fieldser interface {
+	jsonFields() map[string]interface{}
+}
+
+var marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// omitEmpty converts v to its generic JSON form and removes zero-valued
+// object fields. Numbers are kept as json.Number so nothing loses precision.
+func omitEmpty(v interface{}) (interface{}, error) {
+	generic, err := toGeneric(reflect.ValueOf(v))
+	if err != nil {
+		return nil, err
+	}
+	return stripZero(generic), nil
+}
+
+// toGeneric builds the tree of maps, slices and scalars a JSON round trip
+// of v would give, except that zeroer values are kept as they are so
+// stripZero can ask them. Values with their own MarshalJSON are round
+// tripped whole unless they are fieldsers.
+func toGeneric(v reflect.Value) (interface{}, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return nil, nil
+	}
+	if v.CanInterface() {
+		switch t := v.Interface().(type) {
+		case zeroer:
+			return t, nil
+		case fieldser:
+			return genericMap(reflect.ValueOf(t.jsonFields()))
+		}
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return toGeneric(v.Elem())
+	}
+	if v.Type().Implements(marshalerType) {
+		return roundTrip(v.Interface())
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		if quotesFields(v.Type()) {
+			break
+		}
+		out := map[string]interface{}{}
+		if err := structFields(v, out); err != nil {
+			return nil, err
+		}
+		return out, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() == reflect.String {
+			return genericMap(v)
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil, nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			break // []byte encodes as a base64 string
+		}
+		fallthrough
+	case reflect.Array:
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			elem, err := toGeneric(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			out[i] = elem
+		}
+		return out, nil
+	}
+	return roundTrip(v.Interface())
+}
+
+// genericMap converts a map with string keys
+func genericMap(v reflect.Value) (interface{}, error) {
+	if v.IsNil() {
+		return nil, nil
+	}
+	out := make(map[string]interface{}, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		elem, err := toGeneric(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		out[iter.Key().String()] = elem
+	}
+	return out, nil
+}
+
+// structFields adds the JSON fields of struct v to out, following the json
+// tags and flattening untagged embedded structs like encoding/json does
+func structFields(v reflect.Value, out map[string]interface{}) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		name := strings.Split(tag, ",")[0]
+		if tag == "-" || (f.PkgPath != "" && !f.Anonymous) {
+			continue
+		}
+		fv := v.Field(i)
+		if f.Anonymous && name == "" {
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct && !fv.Type().Implements(marshalerType) {
+				if err := structFields(fv, out); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		elem, err := toGeneric(fv)
+		if err != nil {
+			return err
+		}
+		out[name] = elem
+	}
+	return nil
+}
+
+// quotesFields reports whether any field of struct type t uses the json
+// ",string" option, which only encoding/json itself applies correctly
+func quotesFields(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if strings.Contains(t.Field(i).Tag.Get("json"), ",string") {
+			return true
+		}
+	}
+	return false
+}
+
+// roundTrip encodes v and decodes it back into generic form
+func roundTrip(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var generic interface{}
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return generic, nil
+}
+
+func stripZero(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, field := range t {
+			field = stripZero(field)
+			if isZeroJSON(field) {
+				delete(t, k)
+			} else {
+				t[k] = field
+			}
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = stripZero(t[i])
+		}
+	}
+	return v
+}
+
+func isZeroJSON(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return true
+	case zeroer:
+		return t.IsZero()
+	case string:
+		return t == ""
+	case bool:
+		return !t
+	case json.Number:
+		f, err := t.Float64()
+		return err == nil && f == 0
+	case map[string]interface{}:
+		return len(t) == 0
+	case []interface{}:
+		return len(t) == 0
+	}
+	return false
+}
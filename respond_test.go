@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMarshalOptionsOmitEmpty(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   MarshalOptions
+		naming FieldNaming
+		v      interface{}
+		want   string
+	}{
+		{"zero balance omitted", MarshalOptions{OmitEmpty: true}, NamingTags,
+			Account{ID: "1", Name: "a"}, `{"account_id":"1","name":"a"}`},
+		{"non-zero balance kept", MarshalOptions{OmitEmpty: true}, NamingTags,
+			Account{ID: "1", Name: "a", Balance: 1}, `{"account_id":"1","balance":"0.01","name":"a"}`},
+		{"zero balance kept without OmitEmpty", MarshalOptions{}, NamingTags,
+			Account{ID: "1", Name: "a"}, `{"account_id":"1","name":"a","balance":"0.00"}`},
+		{"renamed fields", MarshalOptions{OmitEmpty: true}, NamingCamel,
+			Account{ID: "1"}, `{"accountId":"1"}`},
+		{"nested in envelope and page", MarshalOptions{OmitEmpty: true}, NamingTags,
+			successEnvelope{Data: accountPage{Items: []Account{{ID: "1"}, {ID: "2", Balance: 250}}}},
+			`{"data":{"items":[{"account_id":"1"},{"account_id":"2","balance":"2.50"}]}}`},
+		{"plain values", MarshalOptions{OmitEmpty: true}, NamingTags,
+			map[string]interface{}{"n": 0, "s": "", "b": false, "l": []int{}, "big": int64(1) << 62, "keep": "x"},
+			`{"big":4611686018427387904,"keep":"x"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(n FieldNaming) { AccountNaming = n }(AccountNaming)
+			AccountNaming = tt.naming
+
+			rec := httptest.NewRecorder()
+			tt.opts.Respond(rec, 200, tt.v)
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.want {
+				t.Errorf("body = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyIsZero(t *testing.T) {
+	for _, tt := range []struct {
+		m    Money
+		want bool
+	}{{0, true}, {1, false}, {-1, false}} {
+		if got := tt.m.IsZero(); got != tt.want {
+			t.Errorf("Money(%d).IsZero() = %v, want %v", tt.m, got, tt.want)
+		}
+	}
+}
+
+func TestRespondJSON(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		v          interface{}
+		wantStatus int
+		wantBody   string
+	}{
+		{"struct", http.StatusCreated, struct {
+			ID string `json:"id"`
+		}{"1"}, http.StatusCreated, `{"id":"1"}`},
+		{"nil", http.StatusNoContent, nil, http.StatusNoContent, ""},
+		{"unencodable", http.StatusOK, map[string]interface{}{"c": make(chan int)}, http.StatusInternalServerError, `"code":500`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			captureStdout(t, func() { respondJSON(rec, tt.status, tt.v) })
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			body := strings.TrimSpace(rec.Body.String())
+			if tt.wantBody == "" && body != "" || !strings.Contains(body, tt.wantBody) {
+				t.Errorf("body = %s, want %s", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestMarshalOptionsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     MarshalOptions
+		in       Account
+		wantKeys []string
+	}{
+		{"all fields", MarshalOptions{}, Account{ID: "1", Name: "a", Balance: 250}, []string{"account_id", "balance", "name"}},
+		{"zero balance kept", MarshalOptions{}, Account{ID: "1", Name: "a"}, []string{"account_id", "balance", "name"}},
+		{"zero balance omitted", MarshalOptions{OmitEmpty: true}, Account{ID: "1", Name: "a"}, []string{"account_id", "name"}},
+		{"non-zero balance under OmitEmpty", MarshalOptions{OmitEmpty: true}, Account{ID: "1", Balance: -5}, []string{"account_id", "balance"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.opts.Respond(rec, http.StatusOK, tt.in)
+
+			var keys map[string]json.RawMessage
+			if err := json.Unmarshal(rec.Body.Bytes(), &keys); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for k := range keys {
+				got = append(got, k)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.wantKeys) {
+				t.Errorf("keys = %v, want %v", got, tt.wantKeys)
+			}
+
+			var out Account
+			if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+				t.Fatal(err)
+			}
+			if out != tt.in {
+				t.Errorf("round trip = %+v, want %+v", out, tt.in)
+			}
+		})
+	}
+}
+
+func TestRespondJSONEnvelope(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		raw      bool
+		ctxID    string
+		headerID string
+		wantBody string
+	}{
+		{"envelope", http.StatusOK, false, "", "", `{"data":{"id":"1"}}`},
+		{"created with context id", http.StatusCreated, false, "req-1", "", `{"data":{"id":"1"},"request_id":"req-1"}`},
+		{"header id", http.StatusOK, false, "", "req-2", `{"data":{"id":"1"},"request_id":"req-2"}`},
+		{"context id wins", http.StatusOK, false, "req-1", "req-2", `{"data":{"id":"1"},"request_id":"req-1"}`},
+		{"raw", http.StatusOK, true, "req-1", "", `{"id":"1"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				RespondJSON(w, req, tt.status, map[string]string{"id": "1"})
+			})
+			if tt.raw {
+				h = RawResponses(h)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.ctxID != "" {
+				req = req.WithContext(WithRequestID(req.Context(), tt.ctxID))
+			}
+			if tt.headerID != "" {
+				req.Header.Set("X-Request-ID", tt.headerID)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.wantBody {
+				t.Errorf("body = %s, want %s", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+// unencodable has a field encoding/json cannot marshal
+type unencodable struct {
+	ID      string   `json:"id"`
+	Updates chan int `json:"updates"`
+}
+
+func TestRespondUnencodableIs500(t *testing.T) {
+	v := unencodable{ID: "1", Updates: make(chan int)}
+	tests := []struct {
+		name    string
+		respond func(w http.ResponseWriter, req *http.Request)
+	}{
+		{"respondJSON", func(w http.ResponseWriter, req *http.Request) { respondJSON(w, http.StatusOK, v) }},
+		{"RespondJSON", func(w http.ResponseWriter, req *http.Request) { RespondJSON(w, req, http.StatusOK, v) }},
+		{"raw RespondJSON", func(w http.ResponseWriter, req *http.Request) {
+			RespondJSON(w, req.WithContext(withRawResponse(req.Context())), http.StatusCreated, v)
+		}},
+		{"omit empty", func(w http.ResponseWriter, req *http.Request) {
+			MarshalOptions{OmitEmpty: true}.Respond(w, http.StatusOK, v)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/account/1", nil)
+			captureStdout(t, func() { tt.respond(rec, req) })
+
+			if rec.Code != http.StatusInternalServerError {
+				t.Errorf("status = %d, want 500", rec.Code)
+			}
+			var got APIError
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("body %q is not an APIError: %v", rec.Body.String(), err)
+			}
+			if got.HTTPStatus != http.StatusInternalServerError {
+				t.Errorf("error code = %d, want 500", got.HTTPStatus)
+			}
+			if strings.Contains(rec.Body.String(), `"id"`) {
+				t.Errorf("partial payload leaked: %s", rec.Body.String())
+			}
+		})
+	}
+}
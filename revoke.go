@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RevocationStore remembers revoked access token ids (jti) until the tokens
+// would have expired anyway, after which they are dropped
+type RevocationStore struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	revoked map[string]time.Time
+}
+
+// NewRevocationStore returns a store for the tokens of issuer, whose Clock
+// decides when they expire and so when their revocations lapse
+func NewRevocationStore(issuer *TokenIssuer) *RevocationStore {
+	return &RevocationStore{now: issuer.now, revoked: map[string]time.Time{}}
+}
+
+// Revoke rejects the token with id jti until until
+func (s *RevocationStore) Revoke(jti string, until time.Time) {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, exp := range s.revoked {
+		if now.After(exp) {
+			delete(s.revoked, id)
+		}
+	}
+	s.revoked[jti] = until
+}
+
+// IsRevoked reports whether the token with id jti has been revoked
+func (s *RevocationStore) IsRevoked(jti string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exp, ok := s.revoked[jti]
+	return ok && s.now().Before(exp)
+}
+
+type revokeRequest struct {
+	Token string `json:"token"`
+	JTI   string `json:"jti"`
+}
+
+// RevokeHandler revokes an access token given either as the token itself
+// or as its jti. It goes behind AuthenticateMiddleware: callers may revoke
+// their own tokens, given as a token issued to them or as the jti of the
+// one they authenticated with, while admins may revoke any. As RFC 7009
+// suggests, an unknown or invalid token is not an error: the answer is 200
+// either way, so callers learn nothing about which tokens exist.
+func RevokeHandler(issuer *TokenIssuer, store *RevocationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		p, ok := PrincipalFromContext(req.Context())
+		if !ok {
+			writeError(w, &APIError{HTTPStatus: http.StatusUnauthorized, ErrorCode: "unauthorized", Message: "authentication required", Err: ErrUnauthorized})
+			return
+		}
+		var body revokeRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || (body.Token == "" && body.JTI == "") {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "token or jti required"})
+			return
+		}
+		admin := p.HasRole(adminRole)
+		switch {
+		case body.Token != "":
+			claims, err := issuer.Parse(body.Token)
+			if (err != nil && !errors.Is(err, ErrTokenExpired)) || claims.ID == "" {
+				break
+			}
+			if !admin && claims.Subject != p.ID {
+				writeError(w, &APIError{HTTPStatus: http.StatusForbidden, ErrorCode: "forbidden", Message: "token belongs to another subject", Err: ErrForbidden})
+				return
+			}
+			store.Revoke(claims.ID, time.Unix(claims.ExpiresAt, 0))
+		default:
+			if !admin && body.JTI != p.TokenID {
+				writeError(w, &APIError{HTTPStatus: http.StatusForbidden, ErrorCode: "forbidden", Message: "jti is not the caller's token", Err: ErrForbidden})
+				return
+			}
+			// without the token the expiry is unknown, so hold the id for
+			// the longest a token can live
+			store.Revoke(body.JTI, issuer.now().Add(issuer.TTL))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRevokeHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		callerRoles []string
+		anonymous   bool
+		victim      string // subject of the token being revoked
+		byJTI       bool
+		sameToken   bool // revoke the token the caller authenticates with
+		invalid     bool
+		wantStatus  int
+		wantRevoked bool
+	}{
+		{name: "anonymous", anonymous: true, victim: "1", wantStatus: http.StatusUnauthorized},
+		{name: "own token", victim: "1", wantStatus: http.StatusOK, wantRevoked: true},
+		{name: "own jti", victim: "1", byJTI: true, sameToken: true, wantStatus: http.StatusOK, wantRevoked: true},
+		{name: "other jti of own subject", victim: "1", byJTI: true, wantStatus: http.StatusForbidden},
+		{name: "someone else's token", victim: "2", wantStatus: http.StatusForbidden},
+		{name: "someone else's jti", victim: "2", byJTI: true, wantStatus: http.StatusForbidden},
+		{name: "admin revokes any token", callerRoles: []string{adminRole}, victim: "2", wantStatus: http.StatusOK, wantRevoked: true},
+		{name: "admin revokes any jti", callerRoles: []string{adminRole}, victim: "2", byJTI: true, wantStatus: http.StatusOK, wantRevoked: true},
+		{name: "invalid token", victim: "1", invalid: true, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := &TokenIssuer{Secret: []byte("secret"), TTL: time.Hour}
+			cfg := AuthConfig{Tokens: issuer, Revoked: NewRevocationStore(issuer)}
+			r := NewRouter()
+			RegisterAuthRoutes(r, cfg, NewRefreshTokenStore(time.Hour))
+
+			caller, err := issuer.Issue("1", tt.callerRoles...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			target := caller
+			if !tt.sameToken {
+				if target, err = issuer.Issue(tt.victim); err != nil {
+					t.Fatal(err)
+				}
+			}
+			claims, err := issuer.Parse(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body := `{"token":"` + target + `"}`
+			switch {
+			case tt.invalid:
+				body = `{"token":"not-a-token"}`
+			case tt.byJTI:
+				body = `{"jti":"` + claims.ID + `"}`
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/auth/revoke", strings.NewReader(body))
+			if !tt.anonymous {
+				req.Header.Set("Authorization", "Bearer "+caller)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if revoked := cfg.Revoked.IsRevoked(claims.ID); revoked != tt.wantRevoked {
+				t.Errorf("revoked = %v, want %v", revoked, tt.wantRevoked)
+			}
+		})
+	}
+}
+
+func TestRevokedTokenRejected(t *testing.T) {
+	issuer := &TokenIssuer{Secret: []byte("secret"), TTL: time.Hour}
+	cfg := AuthConfig{Tokens: issuer, Revoked: NewRevocationStore(issuer)}
+	r := NewRouter()
+	RegisterAuthRoutes(r, cfg, NewRefreshTokenStore(time.Hour))
+	tok, err := issuer.Issue("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodGet, "/me", "", http.StatusOK},
+		{http.MethodPost, "/auth/revoke", `{"token":"` + tok + `"}`, http.StatusOK},
+		{http.MethodGet, "/me", "", http.StatusUnauthorized},
+	}
+	for _, s := range steps {
+		req := httptest.NewRequest(s.method, s.path, strings.NewReader(s.body))
+		req.Header.Set("Authorization", "Bearer "+tok)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != s.want {
+			t.Fatalf("%s %s = %d, want %d", s.method, s.path, rec.Code, s.want)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// NewRouter returns the router the service's handlers are mounted on. It
+// matches routes against the escaped path, so an id holding an encoded
+// slash ("a%2Fb") still reaches /account/{id} rather than a 404, and then
+// unescapes each path variable exactly once before any middleware runs.
+// The ownership check and the handler therefore both see "123" for
+// "%31%32%33", and "%2531" stays "%31" instead of being decoded twice.
+func NewRouter() *mux.Router {
+	r := mux.NewRouter().UseEncodedPath()
+	r.Use(unescapeVars, RouteTemplateMiddleware)
+	r.MethodNotAllowedHandler = MethodNotAllowedHandler(r)
+	return r
+}
+
+// MethodNotAllowedHandler answers 405 with an Allow header listing the
+// methods of every route in r whose path matches the request, so it stays
+// accurate as routes are added. Set it as r.MethodNotAllowedHandler.
+func MethodNotAllowedHandler(r *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Allow", strings.Join(allowedMethods(r, req), ", "))
+		respondError(w, req, http.StatusMethodNotAllowed, "method not allowed")
+	})
+}
+
+// allowedMethods collects, sorted, the methods of the routes in r that
+// match req in everything but its method
+func allowedMethods(r *mux.Router, req *http.Request) []string {
+	seen := map[string]bool{}
+	r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		var match mux.RouteMatch
+		if route.Match(req, &match) || match.MatchErr == mux.ErrMethodMismatch {
+			for _, m := range methods {
+				seen[m] = true
+			}
+		}
+		return nil
+	})
+	allowed := make([]string, 0, len(seen))
+	for m := range seen {
+		allowed = append(allowed, m)
+	}
+	sort.Strings(allowed)
+	return allowed
+}
+
+// RouteTemplate stands in for the template of requests that matched no route
+const unmatchedRoute = "unmatched"
+
+// RouteTemplateMiddleware stores the matched route's path template, such as
+// "/account/{id}", in the request context for RouteTemplate. NewRouter
+// installs it, so metrics, logs and audit events all see the same value.
+func RouteTemplateMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		tpl := unmatchedRoute
+		if cur := mux.CurrentRoute(req); cur != nil {
+			if t, err := cur.GetPathTemplate(); err == nil {
+				tpl = t
+			}
+		}
+		next.ServeHTTP(w, req.WithContext(WithRouteTemplate(req.Context(), tpl)))
+	})
+}
+
+// WithRouteTemplate returns a copy of ctx carrying the route template tpl
+func WithRouteTemplate(ctx context.Context, tpl string) context.Context {
+	return context.WithValue(ctx, routeTemplateKey{}, tpl)
+}
+
+// RouteTemplate returns the template RouteTemplateMiddleware stored, or
+// "unmatched" when there is none
+func RouteTemplate(ctx context.Context) string {
+	if tpl, ok := ctx.Value(routeTemplateKey{}).(string); ok {
+		return tpl
+	}
+	return unmatchedRoute
+}
+
+// unescapeVars decodes the route variables in place. The URL was already
+// validated when the request was parsed, so an escape can't fail here; if
+// one somehow does the variable is left as it is.
+func unescapeVars(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		for k, v := range vars {
+			if u, err := url.PathUnescape(v); err == nil {
+				vars[k] = u
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// RouteInfo describes one registered route
+type RouteInfo struct {
+	Path string `json:"path"`
+	// Methods is empty for routes that accept any method
+	Methods []string `json:"methods"`
+	Handler string   `json:"handler"`
+	// Middleware lists, outermost first, what runs around the handler: the
+	// chains registered with UseChain, then what the route was wrapped in
+	// with With. Middleware added with a plain Use isn't tracked.
+	Middleware []string `json:"middleware"`
+}
+
+// ListRoutes walks r, subrouters included, and describes every route that
+// serves requests. Routes that only group subroutes are left out, as are
+// routes matched by something other than a path.
+func ListRoutes(r *mux.Router) ([]RouteInfo, error) {
+	// the routers the middleware was registered on, and which router holds
+	// each route, so a route deep in subrouters picks up every chain above it
+	chains := map[*mux.Router][]string{}
+	holders := map[*mux.Route]*mux.Router{}
+	err := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		holders[route] = router
+		if names, ok := route.GetHandler().(chainMarker); ok {
+			chains[router] = append(chains[router], names...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk routes: %w", err)
+	}
+
+	var routes []RouteInfo
+	err = r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		h := route.GetHandler()
+		if _, marker := h.(chainMarker); h == nil || marker {
+			return nil
+		}
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{}
+		}
+		mws := []string{}
+		for _, a := range ancestors {
+			mws = append(mws, chains[holders[a]]...)
+		}
+		mws = append(mws, chains[router]...)
+		if w, ok := h.(*withHandler); ok {
+			mws = append(mws, w.middleware...)
+			h = w.inner
+		}
+		routes = append(routes, RouteInfo{Path: path, Methods: methods, Handler: handlerName(h), Middleware: mws})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk routes: %w", err)
+	}
+	return routes, nil
+}
+
+// handlerName names a handler after its function when it is one, else its
+// type
+func handlerName(h http.Handler) string {
+	if f, ok := h.(http.HandlerFunc); ok {
+		if name := funcName(reflect.ValueOf(f)); name != "" {
+			return name
+		}
+	}
+	return fmt.Sprintf("%T", h)
+}
+
+// funcName names the function f holds. Closures are named after the
+// function that built them, without the package: "ListAccounts" rather than
+// "pkg.ListAccounts.func1"; method values drop their "-fm" suffix.
+func funcName(f reflect.Value) string {
+	fn := runtime.FuncForPC(f.Pointer())
+	if fn == nil {
+		return ""
+	}
+	name := fn.Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+	name = name[strings.Index(name, ".")+1:]
+	if i := strings.Index(name, ".func"); i > 0 {
+		name = name[:i]
+	}
+	return strings.TrimSuffix(name, "-fm")
+}
+
+// RoutesHandler serves ListRoutes(r) as JSON. It is meant as a debug
+// endpoint, such as GET /admin/routes, and reveals the whole API surface,
+// so keep it behind authentication.
+func RoutesHandler(r *mux.Router) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		routes, err := ListRoutes(r)
+		if err != nil {
+			writeError(rw, err)
+			return
+		}
+		RespondJSON(rw, req, http.StatusOK, routes)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRoutesHandler(t *testing.T) {
+	issuer := &TokenIssuer{Secret: []byte("secret"), TTL: time.Hour}
+	srv, _ := NewTestServer(t, WithAuth(AuthConfig{Tokens: issuer}), WithMaintenance(&AtomicBool{}))
+	tok, err := issuer.Issue("ops", adminRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/admin/routes", nil)
+	req.Header.Set("Authorization", "Bearer "+tok)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Data []RouteInfo `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, method string
+		middleware   []string
+	}{
+		{"/account/{id}", http.MethodGet, []string{"Logging", "Maintenance", "AccountAuthMiddleware"}},
+		{"/accounts", http.MethodPost, []string{"Logging", "Maintenance", "AuthenticateMiddleware"}},
+		{"/admin/routes", http.MethodGet, []string{"Logging", "Maintenance", "Authenticate", "RequireRole(admin)"}},
+		{"/openapi.json", http.MethodGet, []string{"Logging", "Maintenance"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			for _, route := range body.Data {
+				for _, m := range route.Methods {
+					if route.Path == tt.path && m == tt.method {
+						if !reflect.DeepEqual(route.Middleware, tt.middleware) {
+							t.Errorf("middleware = %v, want %v", route.Middleware, tt.middleware)
+						}
+						return
+					}
+				}
+			}
+			t.Errorf("route missing from %+v", body.Data)
+		})
+	}
+}
+
+func TestWithRecordsMiddleware(t *testing.T) {
+	var order []string
+	mark := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, req)
+			})
+		}
+	}
+	h := With(With(http.HandlerFunc(Healthz), mark("inner")), mark("outer"))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if want := []string{"outer", "inner"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("ran %v, want %v", order, want)
+	}
+	w := h.(*withHandler)
+	if handlerName(w.inner) != "Healthz" || len(w.middleware) != 2 {
+		t.Errorf("recorded handler %s with %v", handlerName(w.inner), w.middleware)
+	}
+}
+
+func TestListRoutes(t *testing.T) {
+	r := NewRouter()
+	RegisterAccountRoutes(r, NewMapStore(), AuthConfig{}, nil)
+	r.HandleFunc("/healthz", Healthz)
+	api := r.PathPrefix("/api").Subrouter()
+	v1 := api.PathPrefix("/v1").Subrouter()
+	v1.HandleFunc("/me", WhoAmI).Methods(http.MethodGet)
+
+	routes, err := ListRoutes(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path    string
+		methods []string
+		handler string
+	}{
+		{"/accounts", []string{http.MethodGet}, "ListAccounts"},
+		{"/accounts", []string{http.MethodPost}, "CreateAccount"},
+		{"/account/{id}", []string{http.MethodPatch}, "PatchAccount"},
+		{"/account/{id}", []string{http.MethodPut}, "PutAccount"},
+		{"/healthz", []string{}, "Healthz"},
+		{"/api/v1/me", []string{http.MethodGet}, "WhoAmI"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.handler, func(t *testing.T) {
+			for _, route := range routes {
+				if route.Path == tt.path && reflect.DeepEqual(route.Methods, tt.methods) {
+					if route.Handler != tt.handler {
+						t.Errorf("handler = %q, want %q", route.Handler, tt.handler)
+					}
+					return
+				}
+			}
+			t.Errorf("%v %s missing from %+v", tt.methods, tt.path, routes)
+		})
+	}
+	for _, route := range routes {
+		if route.Path == "/api" || route.Path == "/api/v1" {
+			t.Errorf("subrouter prefix %s listed as a route", route.Path)
+		}
+	}
+}
+
+func TestListRoutesNestedChains(t *testing.T) {
+	pass := func(next http.Handler) http.Handler { return next }
+	declare := func(name string) DeclaredMiddleware { return DeclaredMiddleware{Name: name, Func: pass} }
+
+	r := NewRouter()
+	api := r.PathPrefix("/api").Subrouter()
+	v1 := api.PathPrefix("/v1").Subrouter()
+	admin := r.PathPrefix("/admin").Subrouter()
+	for router, name := range map[*mux.Router]string{r: "Root", api: "API", v1: "V1", admin: "Admin"} {
+		if err := UseChain(router, declare(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.HandleFunc("/healthz", Healthz)
+	api.HandleFunc("/status", Healthz)
+	v1.Handle("/me", With(http.HandlerFunc(WhoAmI), RequireRole(adminRole)))
+	admin.HandleFunc("/routes", Healthz)
+
+	// a second router's chains must not leak into the first
+	other := NewRouter()
+	if err := UseChain(other, declare("Other")); err != nil {
+		t.Fatal(err)
+	}
+
+	routes, err := ListRoutes(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"/healthz":      {"Root"},
+		"/api/status":   {"Root", "API"},
+		"/api/v1/me":    {"Root", "API", "V1", "RequireRole"},
+		"/admin/routes": {"Root", "Admin"},
+	}
+	if len(routes) != len(want) {
+		t.Fatalf("routes = %+v, want %d", routes, len(want))
+	}
+	for _, route := range routes {
+		if !reflect.DeepEqual(route.Middleware, want[route.Path]) {
+			t.Errorf("%s middleware = %v, want %v", route.Path, route.Middleware, want[route.Path])
+		}
+	}
+}
+
+func TestEncodedAccountIDs(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		auth    string
+		want    int
+		wantVar string // id the handler sees
+	}{
+		{"plain", "/account/123", "123", http.StatusOK, "123"},
+		{"percent-encoded", "/account/%31%32%33", "123", http.StatusOK, "123"},
+		{"percent-encoded, other owner", "/account/%31%32%33", "124", http.StatusUnauthorized, ""},
+		{"double-encoded decoded once", "/account/%2531", "%31", http.StatusOK, "%31"},
+		{"double-encoded is not the plain id", "/account/%2531", "1", http.StatusUnauthorized, ""},
+		{"encoded slash", "/account/a%2Fb", "a/b", http.StatusOK, "a/b"},
+		{"raw slash is another route", "/account/a/b", "a/b", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			r := NewRouter()
+			r.Handle("/account/{id}", AuthorizationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				seen = mux.Vars(req)["id"]
+			})))
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("Authorization", tt.auth)
+			rec := httptest.NewRecorder()
+			captureStdout(t, func() { r.ServeHTTP(rec, req) })
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if seen != tt.wantVar {
+				t.Errorf("handler saw id %q, want %q", seen, tt.wantVar)
+			}
+		})
+	}
+}
+
+func TestMethodNotAllowedHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		want      int
+		wantAllow string
+	}{
+		{"POST to GET+HEAD route", http.MethodPost, "/account/1", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"methods of every matching route", http.MethodDelete, "/accounts", http.StatusMethodNotAllowed, "GET, POST"},
+		{"allowed method", http.MethodHead, "/account/1", http.StatusOK, ""},
+		{"unknown path", http.MethodPost, "/nope", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRouter()
+			ok := func(w http.ResponseWriter, req *http.Request) {}
+			r.HandleFunc("/account/{id}", ok).Methods(http.MethodGet, http.MethodHead)
+			r.HandleFunc("/accounts", ok).Methods(http.MethodGet)
+			r.HandleFunc("/accounts", ok).Methods(http.MethodPost)
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", got, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestRouteTemplateMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		routed bool // whether the request goes through NewRouter
+		want   string
+	}{
+		{"path variable", "/account/1", true, "/account/{id}"},
+		{"static path", "/accounts", true, "/accounts"},
+		{"subrouter", "/api/v1/account/7", true, "/api/v1/account/{id}"},
+		{"outside a router", "/account/1", false, unmatchedRoute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			record := func(w http.ResponseWriter, req *http.Request) { seen = RouteTemplate(req.Context()) }
+			r := NewRouter()
+			r.HandleFunc("/account/{id}", record)
+			r.HandleFunc("/accounts", record)
+			r.PathPrefix("/api/v1").Subrouter().HandleFunc("/account/{id}", record)
+
+			var h http.Handler = r
+			if !tt.routed {
+				h = RouteTemplateMiddleware(http.HandlerFunc(record))
+			}
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if seen != tt.want {
+				t.Errorf("RouteTemplate = %q, want %q", seen, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteTemplateWithoutMiddleware(t *testing.T) {
+	if got := RouteTemplate(context.Background()); got != unmatchedRoute {
+		t.Errorf("RouteTemplate = %q, want %q", got, unmatchedRoute)
+	}
+}
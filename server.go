@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// How often Run reports the number of requests still draining during shutdown
+const drainLogInterval = time.Second
+
+// ServerConfig holds the settings used to build the *http.Server
+type ServerConfig struct {
+	Addr string
+
+	// ReadTimeout bounds reading the whole request, body included. Zero
+	// means no limit.
+	ReadTimeout time.Duration
+
+	// MaxHeaderBytes caps the size of the request line plus headers; requests
+	// over it get a 431. Raising it allows larger cookies and tokens but every
+	// connection may buffer up to this much before the handler runs.
+	MaxHeaderBytes int
+
+	// DisableKeepAlives closes the connection after each response. It spreads
+	// load more evenly behind connection-pinning balancers, at the cost of a
+	// new TCP (and TLS) handshake per request.
+	DisableKeepAlives bool
+
+	// MaxURILength rejects request URIs longer than this many bytes with a
+	// 414 before routing. Zero means unlimited.
+	MaxURILength int
+
+	// TrailingSlash decides whether "/account/123/" is redirected to,
+	// served as, or kept distinct from "/account/123"
+	TrailingSlash TrailingSlashMode
+
+	// HandlerTimeout bounds how long any handler may run before the client
+	// gets a 503. Zero means no limit.
+	HandlerTimeout time.Duration
+
+	// TimeoutExclude lists route patterns such as "/account/{id}/events"
+	// exempt from HandlerTimeout. Streaming endpoints belong here, since
+	// the timeout buffers the whole response.
+	TimeoutExclude []string
+
+	// MaxConcurrentRequests caps how many requests are served at once;
+	// the rest get a 503. Zero means unlimited.
+	MaxConcurrentRequests int
+
+	// ConcurrencyQueueTimeout is how long a request waits for one of the
+	// MaxConcurrentRequests slots before getting the 503. Zero means it
+	// doesn't wait.
+	ConcurrencyQueueTimeout time.Duration
+
+	// ShutdownTimeout is the grace period in-flight requests get to finish
+	// once Run begins shutting down
+	ShutdownTimeout time.Duration
+
+	// Init, if set, initializes the service's dependencies. Run calls it
+	// once the listener is bound; until it returns, requests other than
+	// health checks get a 503. An error stops the server and is returned by Run.
+	Init func(context.Context) error
+
+	// AuthSecret is the key the application verifies access tokens with.
+	// Empty disables token authentication.
+	AuthSecret []byte
+
+	// LogLevel is the least severe access log level written: "debug",
+	// "info", "warn" or "error"
+	LogLevel string
+
+	// ErrorFormat is the body shape of every error response. Run applies
+	// it with ErrorFormatMiddleware.
+	ErrorFormat ErrorFormat
+
+	// Lifecycle has its shutdown hooks run after the server stops accepting
+	// requests, within the same grace period. They also run when Init fails
+	// or the server stops on its own, to release what was already set up.
+	// Nil means the package-level one that OnShutdown registers with.
+	Lifecycle *Lifecycle
+}
+
+// DefaultServerConfig returns the settings the service runs with out of the box
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		Addr:                  ":8000",
+		MaxHeaderBytes:        1 << 20, // 1MB
+		MaxURILength:          8 << 10, // 8KB
+		MaxConcurrentRequests: 1000,
+		ShutdownTimeout:       10 * time.Second,
+		LogLevel:              "info",
+	}
+}
+
+// NewServer builds an *http.Server serving h according to cfg
+func NewServer(cfg ServerConfig, h http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:           cfg.Addr,
+		Handler:        h,
+		ReadTimeout:    cfg.ReadTimeout,
+		MaxHeaderBytes: cfg.MaxHeaderBytes,
+	}
+	srv.SetKeepAlivesEnabled(!cfg.DisableKeepAlives)
+	return srv
+}
+
+// RunServer serves h on addr with the default settings until SIGINT or
+// SIGTERM, then shuts down gracefully and runs the hooks registered with
+// OnShutdown. Any error, such as the port already being in use, is
+// returned for the caller to act on.
+func RunServer(addr string, h http.Handler) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cfg := DefaultServerConfig()
+	cfg.Addr = addr
+	return Run(ctx, cfg, h)
+}
+
+// Run binds cfg.Addr and serves h until ctx is cancelled, then shuts the
+// server down, giving in-flight requests up to cfg.ShutdownTimeout to
+// finish. While draining it logs how many requests are still active.
+// Requests arriving before cfg.Init completes are turned away with a 503.
+func Run(ctx context.Context, cfg ServerConfig, h http.Handler) error {
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", cfg.Addr, err)
+	}
+
+	active := &inFlight{}
+	gate := NewStartupGate("/health", "/healthz")
+	h = gate.Middleware(h)
+	h = GlobalTimeoutMiddleware(cfg.HandlerTimeout, cfg.TimeoutExclude...)(h)
+	h = ConcurrencyLimitMiddleware(cfg.MaxConcurrentRequests, cfg.ConcurrencyQueueTimeout)(h)
+	h = active.Middleware(h)
+	h = TrailingSlashMiddleware(cfg.TrailingSlash)(h)
+	h = PathTraversalMiddleware(h)
+	h = MaxURILengthMiddleware(cfg.MaxURILength)(h)
+	h = ErrorFormatMiddleware(cfg.ErrorFormat)(h)
+	srv := NewServer(cfg, h)
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+
+	if cfg.Init != nil {
+		if err := cfg.Init(ctx); err != nil {
+			srv.Close()
+			return runShutdownHooks(cfg, fmt.Errorf("init: %w", err))
+		}
+	}
+	gate.MarkReady()
+
+	select {
+	case err := <-errc:
+		return runShutdownHooks(cfg, err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	done, logged := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(logged)
+		active.logUntilDrained(shutdownCtx, done)
+	}()
+	err = srv.Shutdown(shutdownCtx)
+	close(done)
+	<-logged
+	return joinErrors(err, cfg.lifecycle().Shutdown(shutdownCtx))
+}
+
+// runShutdownHooks releases whatever was set up before the server failed
+// to start or stopped on its own, then returns err along with any hook
+// errors
+func runShutdownHooks(cfg ServerConfig, err error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	return joinErrors(err, cfg.lifecycle().Shutdown(ctx))
+}
+
+// lifecycle is cfg.Lifecycle, or the package-level one when unset
+func (cfg ServerConfig) lifecycle() *Lifecycle {
+	if cfg.Lifecycle == nil {
+		return defaultLifecycle
+	}
+	return cfg.Lifecycle
+}
+
+// joinErrors combines the server's error with the hooks', either of which
+// may be nil
+func joinErrors(err, hookErr error) error {
+	switch {
+	case hookErr == nil:
+		return err
+	case err == nil:
+		return hookErr
+	}
+	return multiError{err, hookErr}
+}
+
+// inFlight counts the requests currently being served
+type inFlight struct {
+	n int64
+}
+
+// Middleware increments the counter and the http_inflight_requests gauge
+// for the lifetime of each request. The decrement is deferred, so a
+// panicking handler is still counted out as the panic unwinds, whether or
+// not something further in recovers it.
+func (f *inFlight) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&f.n, 1)
+		inflightRequests.Inc()
+		defer func() {
+			inflightRequests.Dec()
+			atomic.AddInt64(&f.n, -1)
+		}()
+		next.ServeHTTP(w, req)
+	})
+}
+
+// Count returns the number of requests currently being served
+func (f *inFlight) Count() int64 {
+	return atomic.LoadInt64(&f.n)
+}
+
+// logUntilDrained reports the active count every drainLogInterval until it
+// reaches zero, ctx expires, or done is closed
+func (f *inFlight) logUntilDrained(ctx context.Context, done <-chan struct{}) {
+	ticker := time.NewTicker(drainLogInterval)
+	defer ticker.Stop()
+	for {
+		n := f.Count()
+		fmt.Printf("shutdown: %d request(s) in flight\n", n)
+		if n == 0 {
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		case <-ctx.Done():
+			fmt.Printf("shutdown: grace period expired with %d request(s) in flight\n", f.Count())
+			return
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// captureStdout returns what fn prints to stdout
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = orig }()
+
+	done := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		done <- buf.String()
+	}()
+	fn()
+	w.Close()
+	return <-done
+}
+
+func TestNewServer(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxHeaderBytes int
+		disableKA      bool
+		header         string
+		wantStatus     int
+		wantClose      bool
+	}{
+		{"small header", 4 << 10, false, "x", http.StatusOK, false},
+		{"oversized header", 4 << 10, false, strings.Repeat("x", 16<<10), http.StatusRequestHeaderFieldsTooLarge, true},
+		{"keep-alives disabled", 4 << 10, true, "x", http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultServerConfig()
+			cfg.MaxHeaderBytes = tt.maxHeaderBytes
+			cfg.DisableKeepAlives = tt.disableKA
+			ts := httptest.NewUnstartedServer(nil)
+			ts.Config = NewServer(cfg, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+			ts.Start()
+			defer ts.Close()
+
+			req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+			req.Header.Set("X-Big", tt.header)
+			resp, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if resp.Close != tt.wantClose {
+				t.Errorf("connection closed = %v, want %v", resp.Close, tt.wantClose)
+			}
+		})
+	}
+}
+
+func TestInFlight(t *testing.T) {
+	tests := []struct {
+		name      string
+		inFlight  int
+		panicking bool
+	}{
+		{"idle", 0, false},
+		{"one slow request", 1, false},
+		{"several", 3, false},
+		{"panicking handler counted out", 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &inFlight{}
+			release := make(chan struct{})
+			started := make(chan struct{}, tt.inFlight)
+			h := f.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				started <- struct{}{}
+				<-release
+				if tt.panicking {
+					panic("boom")
+				}
+			}))
+			done := make(chan struct{}, tt.inFlight)
+			for i := 0; i < tt.inFlight; i++ {
+				go func() {
+					defer func() {
+						recover()
+						done <- struct{}{}
+					}()
+					h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+				}()
+				<-started
+			}
+			if got := f.Count(); got != int64(tt.inFlight) {
+				t.Errorf("in flight = %d, want %d", got, tt.inFlight)
+			}
+			out := captureStdout(t, func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+				defer cancel()
+				f.logUntilDrained(ctx, nil)
+			})
+			if want := fmt.Sprintf("shutdown: %d request(s) in flight", tt.inFlight); !strings.Contains(out, want) {
+				t.Errorf("log %q does not contain %q", out, want)
+			}
+			if tt.inFlight > 0 && !strings.Contains(out, "grace period expired") {
+				t.Errorf("log %q does not report the expired grace period", out)
+			}
+
+			close(release)
+			for i := 0; i < tt.inFlight; i++ {
+				<-done
+			}
+			if got := f.Count(); got != 0 {
+				t.Errorf("in flight after completion = %d, want 0", got)
+			}
+		})
+	}
+}
+
+func TestRunListenError(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	tests := []struct {
+		name    string
+		addr    string
+		wantErr string
+	}{
+		{"address in use", taken.Addr().String(), "address already in use"},
+		{"malformed address", "127.0.0.1:notaport", "unknown port"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultServerConfig()
+			cfg.Addr = tt.addr
+			cfg.Lifecycle = &Lifecycle{}
+			err := Run(context.Background(), cfg, http.NotFoundHandler())
+			if err == nil || !strings.Contains(err.Error(), "listen on "+tt.addr) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Run = %v, want a listen error mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunServerErrors(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	tests := []struct {
+		name    string
+		addr    string
+		wantErr string
+	}{
+		{"address in use", taken.Addr().String(), "address already in use"},
+		{"malformed address", "127.0.0.1:notaport", "unknown port"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := RunServer(tt.addr, http.NotFoundHandler())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("RunServer = %v, want an error mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunCleanShutdownIsNotAnError(t *testing.T) {
+	cfg := DefaultServerConfig()
+	cfg.Addr = "127.0.0.1:0"
+	cfg.Lifecycle = &Lifecycle{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var err error
+	captureStdout(t, func() { err = Run(ctx, cfg, http.NotFoundHandler()) })
+	if err != nil {
+		t.Errorf("Run after cancel = %v, want nil", err)
+	}
+}
+
+func TestInFlightGauge(t *testing.T) {
+	tests := []struct {
+		name      string
+		panicking bool
+	}{
+		{"completes", false},
+		{"panics", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := promtestutil.ToFloat64(inflightRequests)
+			release, started, done := make(chan struct{}), make(chan struct{}), make(chan struct{})
+			h := (&inFlight{}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				close(started)
+				<-release
+				if tt.panicking {
+					panic("boom")
+				}
+			}))
+			go func() {
+				defer func() {
+					recover()
+					close(done)
+				}()
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			}()
+			<-started
+			if got := promtestutil.ToFloat64(inflightRequests) - base; got != 1 {
+				t.Errorf("gauge while held = %v, want 1", got)
+			}
+			close(release)
+			<-done
+			if got := promtestutil.ToFloat64(inflightRequests) - base; got != 0 {
+				t.Errorf("gauge after return = %v, want 0", got)
+			}
+		})
+	}
+}
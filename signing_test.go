@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signedRequest builds a POST signed for SignedRequestMiddleware at ts
+func signedRequest(secret []byte, target, body, nonce string, ts time.Time) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	stamp := strconv.FormatInt(ts.Unix(), 10)
+	req.Header.Set("X-Timestamp", stamp)
+	req.Header.Set("X-Nonce", nonce)
+	req.Header.Set("X-Signature", hex.EncodeToString(requestSignature(secret, req, stamp, nonce, []byte(body))))
+	return req
+}
+
+func TestSignedRequestMiddleware(t *testing.T) {
+	secret := []byte("secret")
+	window := time.Minute
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	now := clock.Now()
+	tests := []struct {
+		name   string
+		req    func() *http.Request
+		status int
+	}{
+		{"valid", func() *http.Request {
+			return signedRequest(secret, "/account/1?x=1", `{"a":1}`, "n1", now)
+		}, http.StatusOK},
+		{"expired timestamp", func() *http.Request {
+			return signedRequest(secret, "/account/1", "", "n2", now.Add(-2*window))
+		}, http.StatusUnauthorized},
+		{"future timestamp", func() *http.Request {
+			return signedRequest(secret, "/account/1", "", "n3", now.Add(2*window))
+		}, http.StatusUnauthorized},
+		{"tampered body", func() *http.Request {
+			req := signedRequest(secret, "/account/1", `{"a":1}`, "n4", now)
+			req.Body = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"a":2}`)).Body
+			return req
+		}, http.StatusUnauthorized},
+		{"tampered query", func() *http.Request {
+			req := signedRequest(secret, "/account/1?x=1", "", "n5", now)
+			req.URL.RawQuery = "x=2"
+			return req
+		}, http.StatusUnauthorized},
+		{"tampered timestamp", func() *http.Request {
+			req := signedRequest(secret, "/account/1", "", "n6", now)
+			req.Header.Set("X-Timestamp", strconv.FormatInt(now.Unix()-1, 10))
+			return req
+		}, http.StatusUnauthorized},
+		{"missing nonce", func() *http.Request {
+			req := signedRequest(secret, "/account/1", "", "n7", now)
+			req.Header.Del("X-Nonce")
+			return req
+		}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := SignedRequestMiddleware(secret, NewMapNonceStore(), window, clock)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, tt.req())
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
+
+func TestSignedRequestMiddlewareReplay(t *testing.T) {
+	secret := []byte("secret")
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	h := SignedRequestMiddleware(secret, NewMapNonceStore(), time.Minute, clock)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	now := clock.Now()
+	for i, want := range []string{"", "replayed_nonce"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, signedRequest(secret, "/account/1", "{}", "n1", now))
+		if code := decodeErrorCode(t, rec); code != want {
+			t.Errorf("attempt %d: error = %q, want %q", i+1, code, want)
+		}
+	}
+}
+
+func TestMapNonceStoreExpiry(t *testing.T) {
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	s := NewMapNonceStore()
+	s.Clock = clock
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		advance time.Duration
+		want    bool
+	}{
+		{"first claim", 0, true},
+		{"replay within ttl", time.Minute, false},
+		{"claim after ttl", 2 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		clock.Advance(tt.advance)
+		if fresh, err := s.Claim(ctx, "n1", 2*time.Minute); err != nil || fresh != tt.want {
+			t.Errorf("%s: Claim = %v, %v, want %v", tt.name, fresh, err, tt.want)
+		}
+	}
+}
+
+// decodeErrorCode returns the "error" field of rec's JSON body, or "" for
+// a success
+func decodeErrorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	if rec.Code < 400 {
+		return ""
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode error body %q: %v", rec.Body, err)
+	}
+	return body.Error
+}
+
+// webhookRequest builds a POST signed for SignatureMiddleware at ts
+func webhookRequest(secret []byte, body string, ts time.Time) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+	stamp := strconv.FormatInt(ts.Unix(), 10)
+	req.Header.Set("X-Timestamp", stamp)
+	req.Header.Set("X-Signature", hex.EncodeToString(bodySignature(secret, stamp, []byte(body))))
+	return req
+}
+
+func TestSignatureMiddleware(t *testing.T) {
+	secret := []byte("secret")
+	skew := 5 * time.Minute
+	now := time.Unix(1700000000, 0)
+	body := `{"event":"account.created"}`
+	tests := []struct {
+		name     string
+		req      func() *http.Request
+		want     int
+		wantCode string
+	}{
+		{"valid", func() *http.Request { return webhookRequest(secret, body, now) }, http.StatusOK, ""},
+		{"at the edge of the window", func() *http.Request { return webhookRequest(secret, body, now.Add(-skew)) }, http.StatusOK, ""},
+		{"stale timestamp", func() *http.Request { return webhookRequest(secret, body, now.Add(-skew-time.Second)) }, http.StatusUnauthorized, "stale_timestamp"},
+		{"future timestamp", func() *http.Request { return webhookRequest(secret, body, now.Add(skew+time.Second)) }, http.StatusUnauthorized, "stale_timestamp"},
+		{"tampered body", func() *http.Request {
+			req := webhookRequest(secret, body, now)
+			req.Body = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"event":"account.deleted"}`)).Body
+			return req
+		}, http.StatusUnauthorized, "invalid_signature"},
+		{"tampered timestamp", func() *http.Request {
+			req := webhookRequest(secret, body, now)
+			req.Header.Set("X-Timestamp", strconv.FormatInt(now.Unix()-1, 10))
+			return req
+		}, http.StatusUnauthorized, "invalid_signature"},
+		{"wrong secret", func() *http.Request { return webhookRequest([]byte("other"), body, now) }, http.StatusUnauthorized, "invalid_signature"},
+		{"signature not hex", func() *http.Request {
+			req := webhookRequest(secret, body, now)
+			req.Header.Set("X-Signature", "zz")
+			return req
+		}, http.StatusUnauthorized, "invalid_signature"},
+		{"missing timestamp", func() *http.Request {
+			req := webhookRequest(secret, body, now)
+			req.Header.Del("X-Timestamp")
+			return req
+		}, http.StatusUnauthorized, "invalid_timestamp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			h := SignatureMiddleware(secret, skew, newFakeClock(now))(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				b, _ := io.ReadAll(req.Body)
+				seen = string(b)
+			}))
+			rec := httptest.NewRecorder()
+			captureStdout(t, func() { h.ServeHTTP(rec, tt.req()) })
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusOK && seen != body {
+				t.Errorf("handler read %q, want %q", seen, body)
+			}
+			if tt.wantCode != "" {
+				if code := decodeErrorCode(t, rec); code != tt.wantCode {
+					t.Errorf("error = %q, want %q", code, tt.wantCode)
+				}
+			}
+		})
+	}
+}
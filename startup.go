@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// StartupGate holds requests off with a 503 until the service is ready,
+// so nothing reaches handlers whose dependencies are still initializing.
+// Paths under the exempt prefixes, such as health checks, always pass.
+type StartupGate struct {
+	ready  int32
+	exempt []string
+}
+
+// NewStartupGate returns a closed gate letting through only paths under
+// exemptPrefixes
+func NewStartupGate(exemptPrefixes ...string) *StartupGate {
+	return &StartupGate{exempt: exemptPrefixes}
+}
+
+// MarkReady opens the gate for good
+func (g *StartupGate) MarkReady() {
+	atomic.StoreInt32(&g.ready, 1)
+}
+
+// Ready reports whether MarkReady has been called
+func (g *StartupGate) Ready() bool {
+	return atomic.LoadInt32(&g.ready) == 1
+}
+
+// Middleware answers 503 with Retry-After until the gate is open
+func (g *StartupGate) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !g.Ready() && !pathUnder(req.URL.Path, g.exempt) {
+			w.Header().Set("Retry-After", "1")
+			respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "service starting"})
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
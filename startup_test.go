@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStartupGate(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		ready     bool
+		want      int
+		wantRetry string
+	}{
+		{"before ready", "/account/1", false, http.StatusServiceUnavailable, "1"},
+		{"after ready", "/account/1", true, http.StatusOK, ""},
+		{"health before ready", "/healthz", false, http.StatusOK, ""},
+		{"health subpath before ready", "/health/live", false, http.StatusOK, ""},
+		{"lookalike path before ready", "/healthzz", false, http.StatusServiceUnavailable, "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gate := NewStartupGate("/health", "/healthz")
+			if tt.ready {
+				gate.MarkReady()
+			}
+			h := gate.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.wantRetry {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetry)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+var ErrNoTenant = errors.New("no tenant in context")
+
+// Tenant is a customer whose accounts are kept apart from everyone else's
+type Tenant struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// TenantStore looks up tenants by id
+type TenantStore interface {
+	GetTenant(ctx context.Context, id string) (Tenant, error)
+}
+
+// MapTenantStore is an in-memory TenantStore
+type MapTenantStore map[string]Tenant
+
+func (m MapTenantStore) GetTenant(ctx context.Context, id string) (Tenant, error) {
+	t, ok := m[id]
+	if !ok {
+		return Tenant{}, ErrNotFound
+	}
+	return t, nil
+}
+
+// WithTenant returns a copy of ctx carrying t
+func WithTenant(ctx context.Context, t Tenant) context.Context {
+	return context.WithValue(ctx, tenantKey{}, t)
+}
+
+// TenantFromContext returns the tenant stored by TenantMiddleware
+func TenantFromContext(ctx context.Context) (Tenant, bool) {
+	t, ok := ctx.Value(tenantKey{}).(Tenant)
+	return t, ok
+}
+
+// TenantMiddleware resolves the {tenant} path variable against tenants and
+// stores the Tenant in the request context. Unknown tenants get a 404.
+func TenantMiddleware(tenants TenantStore) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			t, err := tenants.GetTenant(req.Context(), mux.Vars(req)["tenant"])
+			if errors.Is(err, ErrNotFound) {
+				respondJSON(w, http.StatusNotFound, map[string]string{"error": "unknown tenant"})
+				return
+			}
+			if err != nil {
+				respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+				return
+			}
+			next.ServeHTTP(w, req.WithContext(WithTenant(req.Context(), t)))
+		})
+	}
+}
+
+// TenantHeaderMiddleware is TenantMiddleware for routes without a {tenant}
+// path variable: the tenant id comes from the X-Tenant-ID header. A
+// missing header is a 400 when required and otherwise leaves the context
+// without a tenant; an id tenants doesn't know is a 403. It goes after the
+// auth middleware, since the tenant must also be the one the caller's
+// Principal is bound to, or the answer is a 403 too.
+func TenantHeaderMiddleware(tenants TenantStore, required bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			id := req.Header.Get("X-Tenant-ID")
+			if id == "" {
+				if required {
+					writeError(w, ErrNoTenant)
+					return
+				}
+				next.ServeHTTP(w, req)
+				return
+			}
+			t, err := tenants.GetTenant(req.Context(), id)
+			if errors.Is(err, ErrNotFound) {
+				writeError(w, &APIError{HTTPStatus: http.StatusForbidden, ErrorCode: "forbidden", Message: "unknown tenant", Err: ErrForbidden})
+				return
+			}
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			if p, ok := PrincipalFromContext(req.Context()); !ok || p.Tenant != t.ID {
+				writeError(w, &APIError{HTTPStatus: http.StatusForbidden, ErrorCode: "forbidden", Message: "tenant not matched", Err: ErrForbidden})
+				return
+			}
+			next.ServeHTTP(w, req.WithContext(WithTenant(req.Context(), t)))
+		})
+	}
+}
+
+// TenantAccountStore is an AccountStore that keeps a separate store per
+// tenant, picked from the request context, so the same account id under two
+// tenants refers to two different accounts
+type TenantAccountStore struct {
+	newStore func() AccountStore
+
+	mu     sync.Mutex
+	stores map[string]AccountStore
+}
+
+// NewTenantAccountStore returns a store that creates each tenant's backing
+// store with newStore on first use
+func NewTenantAccountStore(newStore func() AccountStore) *TenantAccountStore {
+	return &TenantAccountStore{newStore: newStore, stores: map[string]AccountStore{}}
+}
+
+func (s *TenantAccountStore) scoped(ctx context.Context) (AccountStore, error) {
+	t, ok := TenantFromContext(ctx)
+	if !ok {
+		return nil, ErrNoTenant
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	store, ok := s.stores[t.ID]
+	if !ok {
+		store = s.newStore()
+		s.stores[t.ID] = store
+	}
+	return store, nil
+}
+
+func (s *TenantAccountStore) Get(ctx context.Context, id string) (Account, error) {
+	store, err := s.scoped(ctx)
+	if err != nil {
+		return Account{}, err
+	}
+	return store.Get(ctx, id)
+}
+
+func (s *TenantAccountStore) Put(ctx context.Context, a Account) error {
+	store, err := s.scoped(ctx)
+	if err != nil {
+		return err
+	}
+	return store.Put(ctx, a)
+}
+
+func (s *TenantAccountStore) Create(ctx context.Context, a Account) error {
+	store, err := s.scoped(ctx)
+	if err != nil {
+		return err
+	}
+	return store.Create(ctx, a)
+}
+
+func (s *TenantAccountStore) Update(ctx context.Context, id string, fn func(*Account) error) (Account, error) {
+	store, err := s.scoped(ctx)
+	if err != nil {
+		return Account{}, err
+	}
+	return store.Update(ctx, id, fn)
+}
+
+func (s *TenantAccountStore) List(ctx context.Context, limit int, cursor string) ([]Account, string, error) {
+	store, err := s.scoped(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	return store.List(ctx, limit, cursor)
+}
+
+// RegisterTenantRoutes mounts the account endpoints under /t/{tenant},
+// authenticated under auth and split by rollout like RegisterAccountRoutes.
+// Callers must hold a token bound to the tenant in the path, as
+// IssueForTenant makes; anyone else gets a 403.
+func RegisterTenantRoutes(r *mux.Router, tenants TenantStore, store *TenantAccountStore, auth AuthConfig, rollout *FeatureFlag) {
+	sub := r.PathPrefix("/t/{tenant}").Subrouter()
+	sub.Use(TenantMiddleware(tenants))
+	auth.RequireTenant = true
+	RegisterAccountRoutes(sub, store, auth, rollout)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestTenantIsolation(t *testing.T) {
+	tenants := MapTenantStore{"a": {ID: "a", Name: "A"}, "b": {ID: "b", Name: "B"}}
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		tenant     string // the caller's token is bound to
+		body       string
+		wantStatus int
+		wantName   string
+	}{
+		{"own tenant", http.MethodGet, "/t/a/account/123", "a", "", http.StatusOK, `"name":"alice"`},
+		{"same id in other tenant", http.MethodGet, "/t/b/account/123", "b", "", http.StatusOK, `"name":"bob"`},
+		{"id only in other tenant", http.MethodGet, "/t/b/account/456", "b", "", http.StatusNotFound, ""},
+		{"unknown tenant", http.MethodGet, "/t/c/account/123", "c", "", http.StatusNotFound, "unknown tenant"},
+		{"update in other tenant", http.MethodPatch, "/t/b/account/456", "b", `{"name":"x"}`, http.StatusNotFound, ""},
+		{"cross-tenant read", http.MethodGet, "/t/b/account/123", "a", "", http.StatusForbidden, "tenant not matched"},
+		{"cross-tenant update", http.MethodPatch, "/t/a/account/456", "b", `{"name":"x"}`, http.StatusForbidden, "tenant not matched"},
+		{"token without tenant", http.MethodGet, "/t/a/account/123", "", "", http.StatusForbidden, "tenant not matched"},
+	}
+	issuer := &TokenIssuer{Secret: []byte("k"), TTL: time.Minute}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewTenantAccountStore(func() AccountStore { return NewMapStore() })
+			ctxA := WithTenant(context.Background(), tenants["a"])
+			ctxB := WithTenant(context.Background(), tenants["b"])
+			store.Put(ctxA, Account{ID: "123", Name: "alice"})
+			store.Put(ctxA, Account{ID: "456", Name: "carol"})
+			store.Put(ctxB, Account{ID: "123", Name: "bob"})
+
+			r := NewRouter()
+			RegisterTenantRoutes(r, tenants, store, AuthConfig{Tokens: issuer}, nil)
+			tok, err := issuer.IssueForTenant(tt.tenant, tt.path[strings.LastIndex(tt.path, "/")+1:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+tok)
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantName) {
+				t.Errorf("body = %s, want it to contain %s", rec.Body, tt.wantName)
+			}
+			if a, _ := store.Get(ctxA, "456"); a.Name != "carol" {
+				t.Errorf("tenant a's account 456 = %+v, want it untouched", a)
+			}
+		})
+	}
+}
+
+func TestTenantAccountStoreRequiresTenant(t *testing.T) {
+	store := NewTenantAccountStore(func() AccountStore { return NewMapStore() })
+	if _, err := store.Get(context.Background(), "1"); err != ErrNoTenant {
+		t.Errorf("Get without tenant = %v, want ErrNoTenant", err)
+	}
+}
+
+func TestTenantHeaderMiddleware(t *testing.T) {
+	tenants := MapTenantStore{"a": {ID: "a", Name: "A"}}
+	tests := []struct {
+		name       string
+		required   bool
+		header     string
+		caller     *Principal
+		wantStatus int
+		wantTenant string
+	}{
+		{"present and valid", true, "a", &Principal{ID: "1", Tenant: "a"}, http.StatusOK, "a"},
+		{"missing but required", true, "", &Principal{ID: "1", Tenant: "a"}, http.StatusBadRequest, ""},
+		{"missing and optional", false, "", &Principal{ID: "1", Tenant: "a"}, http.StatusOK, ""},
+		{"unknown", true, "z", &Principal{ID: "1", Tenant: "z"}, http.StatusForbidden, ""},
+		{"unknown and optional", false, "z", &Principal{ID: "1", Tenant: "z"}, http.StatusForbidden, ""},
+		{"other caller's tenant", true, "a", &Principal{ID: "1", Tenant: "b"}, http.StatusForbidden, ""},
+		{"caller without tenant", true, "a", &Principal{ID: "1"}, http.StatusForbidden, ""},
+		{"no caller", true, "a", nil, http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := TenantHeaderMiddleware(tenants, tt.required)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if tenant, ok := TenantFromContext(req.Context()); ok {
+					got = tenant.ID
+				}
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/account/1", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Tenant-ID", tt.header)
+			}
+			if tt.caller != nil {
+				req = req.WithContext(WithPrincipal(req.Context(), tt.caller))
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if got != tt.wantTenant {
+				t.Errorf("tenant = %q, want %q", got, tt.wantTenant)
+			}
+		})
+	}
+}
+
+func TestTenantScopedLookup(t *testing.T) {
+	tenants := MapTenantStore{"a": {ID: "a"}, "b": {ID: "b"}}
+	store := NewTenantAccountStore(func() AccountStore { return NewMapStore() })
+	store.Put(WithTenant(context.Background(), tenants["a"]), Account{ID: "1", Name: "alice"})
+	tests := []struct {
+		tenant     string
+		wantStatus int
+	}{
+		{"a", http.StatusOK},
+		{"b", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tenant, func(t *testing.T) {
+			r := mux.NewRouter()
+			r.Handle("/account/{id}", GetAccountHandler(store))
+			r.Use(TenantHeaderMiddleware(tenants, true))
+			req := httptest.NewRequest(http.MethodGet, "/account/1", nil)
+			req.Header.Set("X-Tenant-ID", tt.tenant)
+			req = req.WithContext(WithPrincipal(req.Context(), &Principal{ID: "1", Tenant: tt.tenant}))
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
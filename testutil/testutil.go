@@ -0,0 +1,49 @@
+// Package testutil holds helpers for exercising middleware in tests.
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// InvokeMiddleware runs req through mw wrapped around a sentinel handler
+// answering 200 and returns the recorded response. next is the request the
+// sentinel received, context included, or nil if the middleware didn't let
+// the request through.
+func InvokeMiddleware(mw mux.MiddlewareFunc, req *http.Request) (rec *httptest.ResponseRecorder, next *http.Request) {
+	sentinel := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		next = req
+		w.WriteHeader(http.StatusOK)
+	})
+	rec = httptest.NewRecorder()
+	mw(sentinel).ServeHTTP(rec, req)
+	return rec, next
+}
+
+// AssertStatus fails the test if the recorded status isn't code
+func AssertStatus(t testing.TB, rec *httptest.ResponseRecorder, code int) {
+	t.Helper()
+	if rec.Code != code {
+		t.Fatalf("status = %d, want %d (body: %q)", rec.Code, code, rec.Body.String())
+	}
+}
+
+// AssertJSONError fails the test unless the response has status code and a
+// JSON body of the form {"error": msg}
+func AssertJSONError(t testing.TB, rec *httptest.ResponseRecorder, code int, msg string) {
+	t.Helper()
+	AssertStatus(t, rec, code)
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body is not JSON: %v (body: %q)", err, rec.Body.String())
+	}
+	if body.Error != msg {
+		t.Fatalf("error = %q, want %q", body.Error, msg)
+	}
+}
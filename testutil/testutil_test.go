@@ -0,0 +1,39 @@
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestInvokeMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		mw       mux.MiddlewareFunc
+		wantCode int
+		wantNext bool
+	}{
+		{"passes through", func(next http.Handler) http.Handler { return next }, http.StatusOK, true},
+		{"short-circuits", func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]string{"error": "nope"})
+			})
+		}, http.StatusForbidden, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, next := InvokeMiddleware(tt.mw, httptest.NewRequest(http.MethodGet, "/", nil))
+			AssertStatus(t, rec, tt.wantCode)
+			if got := next != nil; got != tt.wantNext {
+				t.Errorf("next called = %v, want %v", got, tt.wantNext)
+			}
+			if !tt.wantNext {
+				AssertJSONError(t, rec, tt.wantCode, "nope")
+			}
+		})
+	}
+}
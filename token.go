@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+	ErrTokenRevoked = errors.New("token revoked")
+)
+
+// ClaimValidator checks a verified token's claims, returning an error
+// explaining why they are unacceptable. The error's message is sent to the
+// client as the error_description.
+type ClaimValidator func(*Claims) error
+
+// claimError is a ClaimValidator rejection
+type claimError struct {
+	err error
+}
+
+func (e *claimError) Error() string { return e.err.Error() }
+func (e *claimError) Unwrap() error { return e.err }
+
+// RequireAudience accepts tokens whose aud includes aud
+func RequireAudience(aud string) ClaimValidator {
+	return func(c *Claims) error {
+		for _, a := range c.Audience {
+			if a == aud {
+				return nil
+			}
+		}
+		return fmt.Errorf("audience must include %q", aud)
+	}
+}
+
+// RequireIssuer accepts tokens whose iss is iss
+func RequireIssuer(iss string) ClaimValidator {
+	return func(c *Claims) error {
+		if c.Issuer != iss {
+			return fmt.Errorf("issuer must be %q", iss)
+		}
+		return nil
+	}
+}
+
+// audience is the aud claim, which JWTs carry as either a string or an
+// array of strings
+type audience []string
+
+func (a audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+// Fixed JOSE header for the HS256 tokens issued here
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Claims carried by an access token
+type Claims struct {
+	Subject   string   `json:"sub"`
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp"`
+	ID        string   `json:"jti,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	Tenant    string   `json:"tenant,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  audience `json:"aud,omitempty"`
+}
+
+// TokenIssuer signs and verifies HS256 access tokens
+type TokenIssuer struct {
+	Secret []byte
+	TTL    time.Duration
+	Clock  Clock // defaults to the real clock
+
+	// Issuer and Audience, when set, become the iss and aud of issued tokens
+	Issuer   string
+	Audience []string
+}
+
+func (ti *TokenIssuer) now() time.Time {
+	return clockOrReal(ti.Clock).Now()
+}
+
+// Issue returns a signed access token for sub, granting roles, valid for ti.TTL
+func (ti *TokenIssuer) Issue(sub string, roles ...string) (string, error) {
+	return ti.issue(Claims{Subject: sub, Roles: roles})
+}
+
+// IssueForTenant is Issue for a caller bound to tenant, whose token is only
+// accepted on that tenant's routes
+func (ti *TokenIssuer) IssueForTenant(tenant, sub string, roles ...string) (string, error) {
+	return ti.issue(Claims{Subject: sub, Roles: roles, Tenant: tenant})
+}
+
+// issue fills in the times, id, iss and aud of c and signs it
+func (ti *TokenIssuer) issue(c Claims) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	now := ti.now()
+	c.IssuedAt, c.ExpiresAt, c.ID = now.Unix(), now.Add(ti.TTL).Unix(), jti
+	c.Issuer, c.Audience = ti.Issuer, ti.Audience
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	signing := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signing + "." + ti.sign(signing), nil
+}
+
+// Parse verifies tok and returns its claims. It returns ErrTokenExpired for a
+// correctly signed token past its expiry and ErrInvalidToken for anything else.
+func (ti *TokenIssuer) Parse(tok string) (*Claims, error) {
+	parts := strings.Split(tok, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return nil, ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(ti.sign(parts[0]+"."+parts[1]))) {
+		return nil, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var c Claims
+	if err := json.Unmarshal(payload, &c); err != nil || c.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+	if ti.now().Unix() >= c.ExpiresAt {
+		return &c, ErrTokenExpired
+	}
+	return &c, nil
+}
+
+func (ti *TokenIssuer) sign(s string) string {
+	mac := hmac.New(sha256.New, ti.Secret)
+	mac.Write([]byte(s))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// randomToken returns n random bytes, hex encoded
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
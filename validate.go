@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Largest body ValidateJSONBody will buffer
+const maxValidatedBody = 1 << 20
+
+// FieldError describes one invalid field in a request body
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// BodyValidator checks a decoded JSON object and returns its problems
+type BodyValidator func(doc map[string]interface{}) []FieldError
+
+// ObjectSchema is a minimal JSON object schema: which fields must be
+// present and which JSON type each known field must have ("string",
+// "number", "boolean", "object", "array")
+type ObjectSchema struct {
+	Required []string
+	Types    map[string]string
+}
+
+// Validate implements BodyValidator
+func (s ObjectSchema) Validate(doc map[string]interface{}) []FieldError {
+	var errs []FieldError
+	for _, f := range s.Required {
+		if _, ok := doc[f]; !ok {
+			errs = append(errs, FieldError{Field: f, Message: "required"})
+		}
+	}
+	fields := make([]string, 0, len(s.Types))
+	for f := range s.Types {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	for _, f := range fields {
+		v, ok := doc[f]
+		if ok && v != nil && jsonType(v) != s.Types[f] {
+			errs = append(errs, FieldError{Field: f, Message: "must be " + s.Types[f]})
+		}
+	}
+	return errs
+}
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	return "null"
+}
+
+// ValidateJSONBody runs validate over JSON request bodies before the handler
+// sees them, replying 422 with the field errors on failure. The body is
+// restored for the handler on success. Requests without a body, or whose
+// Content-Type isn't JSON, pass through untouched.
+func ValidateJSONBody(validate BodyValidator) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			mt, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+			if !hasBody(req) || mt != "application/json" {
+				next.ServeHTTP(w, req)
+				return
+			}
+			body, err := io.ReadAll(io.LimitReader(req.Body, maxValidatedBody))
+			req.Body.Close()
+			if err != nil {
+				respondJSON(w, http.StatusBadRequest, map[string]string{"error": "could not read body"})
+				return
+			}
+			var doc map[string]interface{}
+			if err := json.Unmarshal(body, &doc); err != nil {
+				respondJSON(w, http.StatusBadRequest, map[string]string{"error": "malformed JSON"})
+				return
+			}
+			if errs := validate(doc); len(errs) > 0 {
+				respondJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+					"error":  "validation failed",
+					"fields": errs,
+				})
+				return
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// ValidationError reports every invalid field of a request at once, keyed
+// by field name
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string { return "validation failed" }
+
+// Validator accumulates field errors. Only the first failure per field is
+// kept, so a missing field doesn't also report a length error.
+type Validator struct {
+	fields map[string]string
+}
+
+func (v *Validator) fail(field, reason string) {
+	if v.fields == nil {
+		v.fields = map[string]string{}
+	}
+	if _, ok := v.fields[field]; !ok {
+		v.fields[field] = reason
+	}
+}
+
+// Required fails field when value is empty
+func (v *Validator) Required(field, value string) {
+	if value == "" {
+		v.fail(field, "required")
+	}
+}
+
+// Length fails field when value has fewer than min or more than max
+// characters
+func (v *Validator) Length(field, value string, min, max int) {
+	switch n := len([]rune(value)); {
+	case n < min:
+		v.fail(field, "must be at least "+strconv.Itoa(min)+" characters")
+	case n > max:
+		v.fail(field, "must be at most "+strconv.Itoa(max)+" characters")
+	}
+}
+
+// Range fails field when n is outside [min, max]
+func (v *Validator) Range(field string, n, min, max int64) {
+	if n < min || n > max {
+		v.fail(field, "must be between "+strconv.FormatInt(min, 10)+" and "+strconv.FormatInt(max, 10))
+	}
+}
+
+// Err returns a *ValidationError listing the failed fields, or nil
+func (v *Validator) Err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: v.fields}
+}
+
+// RequireQueryParams answers 400 before the handler runs when any of names
+// is absent from the query string, listing each missing one as a field
+// error. A parameter given with no value ("?limit=") counts as present;
+// use RequireNonEmptyQueryParams to reject it too.
+func RequireQueryParams(names ...string) mux.MiddlewareFunc {
+	return requireQuery(names, true)
+}
+
+// RequireNonEmptyQueryParams is RequireQueryParams treating a parameter
+// with an empty value as missing
+func RequireNonEmptyQueryParams(names ...string) mux.MiddlewareFunc {
+	return requireQuery(names, false)
+}
+
+func requireQuery(names []string, allowEmpty bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			query := req.URL.Query()
+			var v Validator
+			for _, name := range names {
+				values, ok := query[name]
+				if !ok || (!allowEmpty && values[0] == "") {
+					v.fail(name, "required")
+				}
+			}
+			if err := v.Err(); err != nil {
+				writeError(w, err)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go-rest-api-example/testutil"
+
+	"github.com/gorilla/mux"
+)
+
+func TestValidateJSONBody(t *testing.T) {
+	schema := ObjectSchema{
+		Required: []string{"name"},
+		Types:    map[string]string{"name": "string", "balance": "number"},
+	}
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		wantStatus  int
+		wantFields  []FieldError
+	}{
+		{"valid", http.MethodPut, "application/json", `{"name":"a","balance":1}`, http.StatusOK, nil},
+		{"missing required field", http.MethodPut, "application/json", `{"balance":1}`, http.StatusUnprocessableEntity,
+			[]FieldError{{Field: "name", Message: "required"}}},
+		{"wrong type", http.MethodPut, "application/json", `{"name":"a","balance":"1"}`, http.StatusUnprocessableEntity,
+			[]FieldError{{Field: "balance", Message: "must be number"}}},
+		{"several problems", http.MethodPut, "application/json", `{"balance":true}`, http.StatusUnprocessableEntity,
+			[]FieldError{{Field: "name", Message: "required"}, {Field: "balance", Message: "must be number"}}},
+		{"malformed", http.MethodPut, "application/json", `{"name":`, http.StatusBadRequest, nil},
+		{"not JSON", http.MethodPut, "text/plain", `anything`, http.StatusOK, nil},
+		{"no body", http.MethodGet, "application/json", "", http.StatusOK, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			h := ValidateJSONBody(schema.Validate)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				b, _ := io.ReadAll(req.Body)
+				seen = string(b)
+			}))
+			req := httptest.NewRequest(tt.method, "/account/1", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus == http.StatusOK && seen != tt.body {
+				t.Errorf("handler read %q, want %q", seen, tt.body)
+			}
+			if tt.wantFields != nil {
+				var body struct {
+					Fields []FieldError `json:"fields"`
+				}
+				json.Unmarshal(rec.Body.Bytes(), &body)
+				if !reflect.DeepEqual(body.Fields, tt.wantFields) {
+					t.Errorf("fields = %+v, want %+v", body.Fields, tt.wantFields)
+				}
+			}
+		})
+	}
+}
+
+func TestRequireQueryParams(t *testing.T) {
+	tests := []struct {
+		name       string
+		mw         mux.MiddlewareFunc
+		query      string
+		wantStatus int
+		wantFields map[string]string
+	}{
+		{"all present", RequireQueryParams("limit", "cursor"), "?limit=10&cursor=abc", http.StatusOK, nil},
+		{"some missing", RequireQueryParams("limit", "cursor"), "?limit=10", http.StatusBadRequest,
+			map[string]string{"cursor": "required"}},
+		{"all missing", RequireQueryParams("limit", "cursor"), "", http.StatusBadRequest,
+			map[string]string{"limit": "required", "cursor": "required"}},
+		{"empty value counts as present", RequireQueryParams("limit"), "?limit=", http.StatusOK, nil},
+		{"empty value rejected", RequireNonEmptyQueryParams("limit", "cursor"), "?limit=&cursor=abc", http.StatusBadRequest,
+			map[string]string{"limit": "required"}},
+		{"non-empty accepted", RequireNonEmptyQueryParams("limit"), "?limit=5", http.StatusOK, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, next := testutil.InvokeMiddleware(tt.mw, httptest.NewRequest(http.MethodGet, "/accounts"+tt.query, nil))
+			testutil.AssertStatus(t, rec, tt.wantStatus)
+			if called := next != nil; called != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("next called = %v", called)
+			}
+			if tt.wantFields != nil {
+				var body APIError
+				json.Unmarshal(rec.Body.Bytes(), &body)
+				if !reflect.DeepEqual(body.Fields, tt.wantFields) {
+					t.Errorf("fields = %v, want %v", body.Fields, tt.wantFields)
+				}
+			}
+		})
+	}
+}
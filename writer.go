@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"net/http"
+)
+
+// responseWriter wraps an http.ResponseWriter and remembers what has been
+// sent, so middleware can inspect the status and size after the handler
+// returns. If tee is set every body write is copied to it as well.
+type responseWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+	tee     io.Writer
+}
+
+func wrapResponseWriter(w http.ResponseWriter) *responseWriter {
+	return &responseWriter{ResponseWriter: w}
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	if rw.status != 0 {
+		return
+	}
+	rw.status = code
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.written += int64(n)
+	if rw.tee != nil {
+		rw.tee.Write(b[:n])
+	}
+	return n, err
+}
+
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Flush passes through to the underlying writer when it supports flushing
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		if rw.status == 0 {
+			rw.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
+
+// Status is the code sent to the client, or 200 if the handler never set one
+func (rw *responseWriter) Status() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+	return rw.status
+}
+
+// Committed reports whether the status line has been sent
+func (rw *responseWriter) Committed() bool {
+	return rw.status != 0
+}
+
+// cappedBuffer keeps the first max bytes written to it and counts the rest
+type cappedBuffer struct {
+	max   int
+	buf   []byte
+	total int
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	c.total += n
+	if room := c.max - len(c.buf); room > 0 {
+		if len(p) > room {
+			p = p[:room]
+		}
+		c.buf = append(c.buf, p...)
+	}
+	return n, nil
+}
+
+// String returns the captured bytes, marking whether anything was cut off
+func (c *cappedBuffer) String() string {
+	if c.total > len(c.buf) {
+		return string(c.buf) + "...(truncated)"
+	}
+	return string(c.buf)
+}